@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jbvmio/scriptrunner"
+	"go.uber.org/zap"
+)
+
+// agent holds the resolved settings used to fetch, run and report archives.
+type agent struct {
+	logger      *zap.Logger
+	hostname    string
+	scripts     string
+	workspace   string
+	homeBaseURL string
+	apiURL      string
+	remoteDir   string
+	client      *http.Client
+	state       *scriptrunner.State
+	schedules   map[string]scriptrunner.ScheduleSpec
+	windows     []scriptrunner.MaintenanceWindow
+	scheduled   bool
+	lastCycle   time.Time
+	verifyKey   ed25519.PublicKey
+	keepFailed  bool
+	onFailure   scriptrunner.FailurePolicy
+	powershell  string
+	timeout     time.Duration
+	concurrency int
+	retention   scriptrunner.Retention
+	labels      map[string]string
+	vars        map[string]string
+	secretsFile string
+	secretKey   string
+	scrubber    *scriptrunner.Scrubber
+	queue       *scriptrunner.ReportQueue
+	flushing    int32
+	tls         scriptrunner.TLSConfig
+	configPath  string
+
+	updateKey     ed25519.PublicKey
+	updateState   string
+	healthTimeout time.Duration
+	restartExe    string
+	outputDir     string
+	out           io.Writer
+
+	reportFormat scriptrunner.ReportFormat
+	reportFile   string
+
+	renewAfter float64
+
+	lock         sync.Mutex
+	status       scriptrunner.ClientState
+	lastRunID    string
+	certNotAfter time.Time
+}
+
+// runOnce performs a single cycle: fetching archives from HomeBase, running the local archives
+// and reporting the results. No new scripts are started once ctx is canceled.
+func (a *agent) runOnce(ctx context.Context) *scriptrunner.RunReport {
+	L := a.logger
+	started := time.Now()
+	var runID string
+	a.setStatus(scriptrunner.ClientRunning, "")
+	a.heartbeat()
+	defer func() {
+		a.setStatus(scriptrunner.ClientIdle, runID)
+		a.heartbeat()
+	}()
+	since := a.lastCycle
+	a.lastCycle = started
+	stale, err := scriptrunner.CleanStaleWorkspaces(a.workspace)
+	if err != nil {
+		L.Error("error cleaning stale workspaces", zap.Error(err))
+	}
+	if len(stale) > 0 {
+		L.Info("removed stale workspaces", zap.Strings("workspaces", stale))
+	}
+
+	if a.client != nil && a.homeBaseURL != "" {
+		L.Info("fetching archives", zap.String("homeBase", a.homeBaseURL), zap.String("remoteDir", a.remoteDir))
+		fetched, err := fetchArchives(a.client, a.homeBaseURL, a.remoteDir, a.scripts, L)
+		if err != nil {
+			L.Error("error fetching archives", zap.Error(err))
+		}
+		L.Info("archives fetched", zap.Strings("archives", fetched))
+	}
+
+	files, err := scriptrunner.GetArchiveFiles(a.scripts)
+	if err != nil {
+		L.Error("error retrieving scripts directory", zap.String("directory", a.scripts), zap.Error(err))
+		return nil
+	}
+	L.Info("script archives discovered", zap.Int("archives", len(files)), zap.Strings("scripts", files))
+
+	ws, err := scriptrunner.NewWorkspace(a.workspace, scriptrunner.NewRunID())
+	if err != nil {
+		L.Error("error creating run workspace", zap.Error(err))
+		return nil
+	}
+	runID = ws.RunID
+	L = L.With(zap.String("runID", ws.RunID))
+	L.Info("run workspace created", zap.String("directory", ws.RunDir))
+
+	secrets, err := a.loadSecrets()
+	if err != nil {
+		L.Error("error loading secrets", zap.Error(err))
+	}
+	facts := a.checkIn()
+	R := runner{
+		ws:         ws,
+		state:      a.state,
+		schedules:  a.schedules,
+		windows:    a.windows,
+		labels:     facts.SelectorLabels(),
+		facts:      &facts.HostFacts,
+		vars:       a.vars,
+		secrets:    secrets,
+		scrubber:   a.scrubber,
+		scheduled:  a.scheduled,
+		since:      since,
+		started:    started,
+		verifyKey:  a.verifyKey,
+		keepFailed: a.keepFailed,
+		onFailure:  a.onFailure,
+		powershell: a.powershell,
+		timeout:    a.timeout,
+		outputDir:  a.outputDir,
+		out:        a.out,
+		logger:     L,
+	}
+	report := scriptrunner.RunReport{
+		RunID:   ws.RunID,
+		Host:    a.hostname,
+		Started: started.UTC(),
+	}
+	report.Archives = R.runArchives(ctx, loadArchives(a.scripts, files, L), a.concurrency)
+	report.Finished = time.Now().UTC()
+	a.scrubber.ScrubReport(&report)
+	L.Info("run complete", zap.Any("archives", summarize(report.Archives)))
+	if a.client != nil && a.apiURL != "" {
+		a.deliverReport(&report, L)
+	}
+	if a.reportFormat != "" {
+		if err := a.writeReport(&report); err != nil {
+			L.Error("error writing report", zap.Error(err))
+		}
+	}
+
+	if err := ws.Close(); err != nil {
+		L.Error("error cleaning run workspace", zap.Error(err))
+	}
+	pruned, err := scriptrunner.PruneFailedWorkspaces(a.workspace, a.retention)
+	if err != nil {
+		L.Error("error pruning failed workspaces", zap.Error(err))
+	}
+	if len(pruned) > 0 {
+		L.Info("pruned failed workspaces", zap.Strings("workspaces", pruned))
+	}
+	return &report
+}
+
+// writeReport writes the report in the configured format to the report file, or to stdout if none is configured.
+func (a *agent) writeReport(report *scriptrunner.RunReport) error {
+	if a.reportFile == "" {
+		return report.Write(os.Stdout, a.reportFormat)
+	}
+	var buf bytes.Buffer
+	if err := report.Write(&buf, a.reportFormat); err != nil {
+		return err
+	}
+	if err := scriptrunner.WriteFileAtomic(a.reportFile, buf.Bytes(), 0640); err != nil {
+		return fmt.Errorf("error writing report file: %w", err)
+	}
+	a.logger.Info("report written", zap.String("file", a.reportFile), zap.String("format", string(a.reportFormat)))
+	return nil
+}
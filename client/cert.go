@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/jbvmio/scriptrunner"
+	"go.uber.org/zap"
+)
+
+const (
+	renewPath = `/renew`
+
+	defaultRenewAfter = 0.67
+)
+
+// checkCert records the expiry of the client certificate for heartbeats and renews the certificate
+// through HomeBase once the configured share of its lifetime has passed.
+func (a *agent) checkCert() {
+	if a.client == nil || a.renewAfter <= 0 {
+		return
+	}
+	L := a.logger.With(zap.String("cert", a.tls.Cert))
+	b, err := ioutil.ReadFile(a.tls.Cert)
+	if err != nil {
+		L.Warn("error reading client certificate", zap.Error(err))
+		return
+	}
+	cert, err := parseCert(b)
+	if err != nil {
+		L.Warn("error reading client certificate", zap.Error(err))
+		return
+	}
+	a.setCertNotAfter(cert.NotAfter)
+	renewAt := cert.NotBefore.Add(time.Duration(float64(cert.NotAfter.Sub(cert.NotBefore)) * a.renewAfter))
+	fields := []zap.Field{zap.Time("notAfter", cert.NotAfter), zap.Time("renewAt", renewAt)}
+	switch now := time.Now(); {
+	case now.After(cert.NotAfter):
+		L.Error("client certificate expired, enroll again to reach HomeBase", fields...)
+		return
+	case now.Before(renewAt):
+		L.Debug("client certificate valid", fields...)
+		return
+	case a.apiURL == "":
+		L.Warn("client certificate due for renewal but no HomeBase API is configured", fields...)
+		return
+	}
+	L.Info("renewing client certificate", fields...)
+	notAfter, err := a.renewCert(cert.Subject.CommonName)
+	if err != nil {
+		L.Error("error renewing client certificate", zap.Error(err))
+		return
+	}
+	a.setCertNotAfter(notAfter)
+	a.client.CloseIdleConnections()
+	L.Info("client certificate renewed", zap.Time("notAfter", notAfter))
+}
+
+// renewCert requests a new certificate for the current key and common name from HomeBase, authenticating with the
+// current certificate, and saves it. Keeping the key leaves secrets encrypted to it readable.
+func (a *agent) renewCert(name string) (time.Time, error) {
+	k, err := scriptrunner.LoadPrivateKey(a.tls.Key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	key, ok := k.(crypto.Signer)
+	if !ok {
+		return time.Time{}, fmt.Errorf("unsupported key type %T", k)
+	}
+	certPEM, err := requestCert(a.client, strings.TrimRight(a.apiURL, `/`)+renewPath, "", name, key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return saveCert(a.tls.Cert, certPEM)
+}
+
+// setCertNotAfter updates the certificate expiry sent with the next heartbeat.
+func (a *agent) setCertNotAfter(notAfter time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.certNotAfter = notAfter
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jbvmio/scriptrunner"
+	"go.uber.org/zap"
+)
+
+const (
+	registerPath  = `/clients/register`
+	heartbeatPath = `/clients/heartbeat`
+
+	defaultHeartbeat = time.Minute
+)
+
+// checkIn returns the CheckIn describing the current host and activity of the client.
+func (a *agent) checkIn() *scriptrunner.CheckIn {
+	facts, err := scriptrunner.GetHostFacts(buildTime, commitHash, a.labels)
+	if err != nil {
+		a.logger.Warn("error gathering host facts", zap.Error(err))
+	}
+	if facts.Hostname == "" {
+		facts.Hostname = a.hostname
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return &scriptrunner.CheckIn{
+		HostFacts:    *facts,
+		State:        a.status,
+		LastRunID:    a.lastRunID,
+		CertNotAfter: a.certNotAfter,
+	}
+}
+
+// setStatus updates the ClientState sent with the next heartbeat.
+func (a *agent) setStatus(status scriptrunner.ClientState, runID string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.status = status
+	if runID != "" {
+		a.lastRunID = runID
+	}
+}
+
+// register registers the client with the HomeBase API.
+func (a *agent) register() error {
+	if a.client == nil || a.apiURL == "" {
+		return nil
+	}
+	if _, err := postJSON(a.client, strings.TrimRight(a.apiURL, `/`)+registerPath, a.checkIn()); err != nil {
+		return fmt.Errorf("error registering client: %w", err)
+	}
+	a.logger.Info("client registered", zap.String("api", a.apiURL))
+	return nil
+}
+
+// heartbeat sends the current state of the client to the HomeBase API, registering again if HomeBase does not know it.
+// Once HomeBase is reachable, any queued reports are sent.
+func (a *agent) heartbeat() {
+	if a.client == nil || a.apiURL == "" {
+		return
+	}
+	code, err := postJSON(a.client, strings.TrimRight(a.apiURL, `/`)+heartbeatPath, a.checkIn())
+	switch {
+	case code == http.StatusNotFound:
+		if err := a.register(); err != nil {
+			a.logger.Error("error sending heartbeat", zap.Error(err))
+		}
+	case err != nil:
+		a.logger.Error("error sending heartbeat", zap.Error(err))
+		return
+	}
+	a.flushReports()
+}
+
+// runHeartbeats sends a heartbeat after every interval until ctx is canceled.
+func (a *agent) runHeartbeats(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.heartbeat()
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jbvmio/scriptrunner"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultHTTPTimeout = time.Minute
+
+	defaultQueueMaxAge    = 30 * 24 * time.Hour
+	defaultQueueMaxSizeMB = 100
+)
+
+// loadConfig reads the config file at the given filepath, overlays the SCRIPTRUNNER_* environment variables and
+// validates the result. A missing config file is only an error if required, and whether it was found is returned.
+func loadConfig(path string, required bool) (*scriptrunner.Config, bool, error) {
+	found := true
+	C, err := scriptrunner.GetConfig(path)
+	switch {
+	case os.IsNotExist(err) && !required:
+		found = false
+	case err != nil:
+		return C, true, err
+	}
+	if err := C.ApplyEnv(); err != nil {
+		return C, found, fmt.Errorf("error applying environment: %w", err)
+	}
+	return C, found, C.Validate()
+}
+
+// applyDefaults sets the default value of each unset option that has one.
+func applyDefaults(C *scriptrunner.Config) {
+	setString := func(s *string, v string) {
+		if *s == "" {
+			*s = v
+		}
+	}
+	setString(&C.ScriptsDir, scriptsDir)
+	setString(&C.WorkspaceDir, workspaceDir)
+	setString(&C.CertsDir, certsDir)
+	setString(&C.StateFile, stateFile)
+	setString(&C.LockFile, lockFile)
+	setString(&C.LogLevel, `info`)
+	setString(&C.TLS.CACert, filepath.Join(C.CertsDir, caCert))
+	setString(&C.TLS.Cert, filepath.Join(C.CertsDir, clientCert))
+	setString(&C.TLS.Key, filepath.Join(C.CertsDir, clientKey))
+	setString(&C.TLS.MinVersion, `1.2`)
+	setString(&C.ReportQueue.Dir, queueDir)
+	if C.FailurePolicy == "" {
+		C.FailurePolicy = scriptrunner.FailContinue
+	}
+	if C.Concurrency == 0 {
+		C.Concurrency = 1
+	}
+	if C.Interval == 0 {
+		C.Interval = defaultInterval
+	}
+	if C.Heartbeat == 0 {
+		C.Heartbeat = defaultHeartbeat
+	}
+	if C.HTTPTimeout == 0 {
+		C.HTTPTimeout = defaultHTTPTimeout
+	}
+	if C.ReportQueue.MaxAge == 0 {
+		C.ReportQueue.MaxAge = defaultQueueMaxAge
+	}
+	if C.TLS.RenewAfter == 0 {
+		C.TLS.RenewAfter = defaultRenewAfter
+	}
+	if C.Update.HealthTimeout == 0 {
+		C.Update.HealthTimeout = defaultHealthTimeout
+	}
+	if C.ReportQueue.MaxSizeMB == 0 {
+		C.ReportQueue.MaxSizeMB = defaultQueueMaxSizeMB
+	}
+}
+
+// resolvePath returns path relative to dir unless it is empty or absolute.
+func resolvePath(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// resolveTLS returns the TLS settings with their paths relative to dir, replaced by the given files if set.
+func resolveTLS(dir string, t scriptrunner.TLSConfig, caCert, cert, key string) scriptrunner.TLSConfig {
+	t.CACert = resolvePath(dir, t.CACert)
+	t.Cert = resolvePath(dir, t.Cert)
+	t.Key = resolvePath(dir, t.Key)
+	if caCert != "" {
+		t.CACert = caCert
+	}
+	if cert != "" {
+		t.Cert = cert
+	}
+	if key != "" {
+		t.Key = key
+	}
+	return t
+}
+
+// executorPath returns the executable path relative to dir, leaving bare executable names to be looked up in the PATH.
+func executorPath(dir, exe string) string {
+	if !strings.ContainsAny(exe, `/\`) {
+		return exe
+	}
+	return resolvePath(dir, exe)
+}
+
+// newLogger returns the client logger at the given level, writing to the given file if set or to out.
+// Secret values registered with the scrubber are masked in every entry.
+func newLogger(level, file string, out *os.File, scrubber *scriptrunner.Scrubber) (*zap.Logger, error) {
+	if file != "" {
+		var err error
+		out, err = os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+		if err != nil {
+			return nil, fmt.Errorf("error opening log file: %w", err)
+		}
+	}
+	l := scriptrunner.ConfigureLogger(scriptrunner.ConfigureLevel(level), scrubber.WriteSyncer(out))
+	return l.With(zap.String(`process`, `scriptrunner`)), nil
+}
+
+// runConfig validates the config and prints the effective configuration, including defaults and environment overrides.
+// The enrollment token is masked in the printed configuration.
+func runConfig(args []string) {
+	var path string
+	pf := pflag.NewFlagSet("scriptrunner config", pflag.ExitOnError)
+	pf.StringVarP(&path, `config`, `c`, "", "Path of Config File to Validate. Defaults to config.yaml within the Current Directory.")
+	pf.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: scriptrunner config validate [flags]\n")
+		pf.PrintDefaults()
+	}
+	pf.Parse(args)
+	if pf.NArg() != 1 || pf.Arg(0) != `validate` {
+		pf.Usage()
+		os.Exit(exitConfigError)
+	}
+	cwd, err := scriptrunner.GetCWD()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error retrieving cwd: %v\n", err)
+		os.Exit(exitConfigError)
+	}
+	if path == "" {
+		path = filepath.Join(cwd, configFile)
+	}
+	C, found, err := loadConfig(path, pf.Changed(`config`))
+	if err == nil {
+		err = checkConfigFiles(cwd, C)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config %s: %v\n", path, err)
+		os.Exit(exitConfigError)
+	}
+	if !found {
+		fmt.Fprintf(os.Stderr, "config file %s not found, using defaults\n", path)
+	}
+	applyDefaults(C)
+	printed := *C
+	if printed.EnrollToken != "" {
+		printed.EnrollToken = scriptrunner.ScrubMask
+	}
+	b, err := yaml.Marshal(&printed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error marshaling config: %v\n", err)
+		os.Exit(exitConfigError)
+	}
+	os.Stdout.Write(b)
+}
+
+// checkConfigFiles loads the schedule file, verify keys and secrets file referenced by the config, if any.
+func checkConfigFiles(cwd string, C *scriptrunner.Config) error {
+	if C.ScheduleFile != "" {
+		if _, err := scriptrunner.LoadSchedules(resolvePath(cwd, C.ScheduleFile)); err != nil {
+			return err
+		}
+	}
+	if C.VerifyKey != "" {
+		if _, err := scriptrunner.LoadVerifyKey(resolvePath(cwd, C.VerifyKey)); err != nil {
+			return fmt.Errorf("error loading archive verify key: %w", err)
+		}
+	}
+	if C.Update.VerifyKey != "" {
+		if _, err := scriptrunner.LoadVerifyKey(resolvePath(cwd, C.Update.VerifyKey)); err != nil {
+			return fmt.Errorf("error loading update verify key: %w", err)
+		}
+	}
+	if C.SecretsFile != "" {
+		if _, err := scriptrunner.LoadSecrets(resolvePath(cwd, C.SecretsFile)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
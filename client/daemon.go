@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// runDaemon runs a cycle after every interval, each delayed by a random splay, until ctx is canceled
+// or the client updates itself and must restart.
+// The first cycle is only delayed by the splay so that hosts started together do not reach HomeBase at once.
+func (a *agent) runDaemon(ctx context.Context, interval, splay time.Duration) {
+	a.logger.Info("daemon mode", zap.Duration("interval", interval), zap.Duration("splay", splay))
+	rand.Seed(time.Now().UnixNano())
+	a.scheduled = true
+	a.lastCycle = time.Now()
+	delay := jitter(splay)
+	for {
+		a.logger.Info("next cycle scheduled", zap.Duration("in", delay))
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		a.runOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if a.restartExe = a.selfUpdate(); a.restartExe != "" {
+			return
+		}
+		a.checkCert()
+		delay = interval + jitter(splay)
+	}
+}
+
+// jitter returns a random duration between zero and max.
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jbvmio/scriptrunner"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+)
+
+const enrollPath = `/enroll`
+
+// enroll generates a new key and certificate signing request for the given common name and submits it to HomeBase
+// with the one-time token. The issued certificate and key are saved to the configured paths and its expiry returned.
+// Both are written to temporary files first and only moved into place once both are written, so that a failed
+// enrollment never leaves a key on disk that does not match the certificate.
+func enroll(t scriptrunner.TLSConfig, api, token, name string, timeout time.Duration) (time.Time, error) {
+	client, err := newTLSClient(t, timeout, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error generating key: %w", err)
+	}
+	certPEM, err := requestCert(client, strings.TrimRight(api, `/`)+enrollPath, token, name, key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	cert, err := parseCert(certPEM)
+	if err != nil {
+		return time.Time{}, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error marshaling key: %w", err)
+	}
+	for _, f := range []string{t.Key, t.Cert} {
+		if err := scriptrunner.CreateDir(filepath.Dir(f)); err != nil {
+			return time.Time{}, fmt.Errorf("error creating certs directory: %w", err)
+		}
+	}
+	keyTmp, certTmp := t.Key+`.new`, t.Cert+`.new`
+	defer os.Remove(keyTmp)
+	defer os.Remove(certTmp)
+	if err := scriptrunner.WriteFileAtomic(keyTmp, pem.EncodeToMemory(&pem.Block{Type: `PRIVATE KEY`, Bytes: der}), 0600); err != nil {
+		return time.Time{}, fmt.Errorf("error writing key: %w", err)
+	}
+	if err := scriptrunner.WriteFileAtomic(certTmp, certPEM, 0644); err != nil {
+		return time.Time{}, fmt.Errorf("error writing certificate: %w", err)
+	}
+	if err := os.Rename(keyTmp, t.Key); err != nil {
+		return time.Time{}, fmt.Errorf("error installing key: %w", err)
+	}
+	if err := os.Rename(certTmp, t.Cert); err != nil {
+		return time.Time{}, fmt.Errorf("error installing certificate: %w", err)
+	}
+	return cert.NotAfter, nil
+}
+
+// enrollClient enrolls with HomeBase using the token unless a client certificate exists by now, and configures
+// the HomeBase client with the certificate. It runs once the instance lock is held, so that overlapping
+// invocations cannot replace each other's key and certificate.
+func (a *agent) enrollClient(token string, timeout time.Duration) {
+	L := a.logger
+	if _, err := os.Stat(a.tls.Cert); os.IsNotExist(err) {
+		L.Info("client certificate not found, enrolling", zap.String("api", a.apiURL), zap.String("cert", a.tls.Cert))
+		notAfter, err := enroll(a.tls, a.apiURL, token, a.hostname, timeout)
+		if err != nil {
+			L.Error("error enrolling with HomeBase", zap.Error(err))
+			return
+		}
+		L.Info("client enrolled", zap.String("cert", a.tls.Cert), zap.Time("notAfter", notAfter))
+	}
+	client, err := newHTTPClient(a.tls, timeout)
+	if err != nil {
+		L.Error("error configuring HomeBase client", zap.Error(err))
+		return
+	}
+	a.client = client
+}
+
+// requestCert submits a certificate signing request for the given common name and key to the HomeBase
+// enrollment or renewal endpoint U and returns the issued PEM encoded certificate once verified to match the key.
+func requestCert(client *http.Client, U, token, name string, key crypto.Signer) ([]byte, error) {
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: name},
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating certificate request: %w", err)
+	}
+	var E scriptrunner.EnrollResponse
+	req := scriptrunner.EnrollRequest{
+		Token: token,
+		CSR:   string(pem.EncodeToMemory(&pem.Block{Type: `CERTIFICATE REQUEST`, Bytes: der})),
+	}
+	if _, err := exchangeJSON(client, U, &req, &E); err != nil {
+		return nil, err
+	}
+	cert, err := parseCert([]byte(E.Certificate))
+	if err != nil {
+		return nil, err
+	}
+	want, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling public key: %w", err)
+	}
+	if !bytes.Equal(cert.RawSubjectPublicKeyInfo, want) {
+		return nil, fmt.Errorf("received certificate does not match key")
+	}
+	return []byte(E.Certificate), nil
+}
+
+// saveCert writes the PEM encoded certificate to the given filepath and returns its expiry.
+func saveCert(certFile string, certPEM []byte) (time.Time, error) {
+	cert, err := parseCert(certPEM)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := scriptrunner.CreateDir(filepath.Dir(certFile)); err != nil {
+		return time.Time{}, fmt.Errorf("error creating certs directory: %w", err)
+	}
+	if err := scriptrunner.WriteFileAtomic(certFile, certPEM, 0644); err != nil {
+		return time.Time{}, fmt.Errorf("error writing certificate: %w", err)
+	}
+	return cert.NotAfter, nil
+}
+
+// parseCert decodes a PEM encoded certificate.
+func parseCert(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != `CERTIFICATE` {
+		return nil, fmt.Errorf("no PEM certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// runEnroll obtains a client certificate from HomeBase using a one-time token.
+func runEnroll(args []string) {
+	var path, api, caCert, token, name string
+	var force bool
+	pf := pflag.NewFlagSet("scriptrunner enroll", pflag.ExitOnError)
+	pf.StringVarP(&path, `config`, `c`, "", "Path of Config File to Use. Defaults to config.yaml within the Executable Directory.")
+	pf.StringVar(&api, `api`, "", "Alternate HomeBase API URL to Enroll with, Overwrites Config HomeBaseAPI Value.")
+	pf.StringVar(&caCert, `cacert`, "", "Filepath to HomeBase Signing Certificate CA. Defaults to ca.crt within the Certs Directory.")
+	pf.StringVar(&token, `token`, "", "One-Time Enrollment Token Created by homebase token create.")
+	pf.StringVar(&name, `name`, "", "Common Name of the Client Certificate. (default hostname)")
+	pf.BoolVar(&force, `force`, false, "Replace an Existing Client Certificate.")
+	pf.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: scriptrunner enroll --token <token> [flags]\n")
+		pf.PrintDefaults()
+	}
+	pf.Parse(args)
+	if pf.NArg() != 0 || token == "" {
+		pf.Usage()
+		os.Exit(exitConfigError)
+	}
+	fail := func(code int, format string, a ...interface{}) {
+		fmt.Fprintf(os.Stderr, format+"\n", a...)
+		os.Exit(code)
+	}
+	cwd, err := scriptrunner.GetCWD()
+	if err != nil {
+		fail(exitConfigError, "error retrieving cwd: %v", err)
+	}
+	if path == "" {
+		path = filepath.Join(cwd, configFile)
+	}
+	C, _, err := loadConfig(path, pf.Changed(`config`))
+	if err != nil {
+		fail(exitConfigError, "invalid config %s: %v", path, err)
+	}
+	applyDefaults(C)
+	if api == "" {
+		api = C.HomeBaseAPI
+	}
+	if api == "" {
+		fail(exitConfigError, "no HomeBase API configured")
+	}
+	if name == "" {
+		if name, err = os.Hostname(); err != nil {
+			fail(exitConfigError, "error retrieving hostname: %v", err)
+		}
+	}
+	lock, err := scriptrunner.AcquireLock(resolvePath(cwd, C.LockFile))
+	if err != nil {
+		fail(exitLocked, "error acquiring lock, another instance may be running: %v", err)
+	}
+	defer lock.Release()
+	t := resolveTLS(cwd, C.TLS, caCert, "", "")
+	if _, err := os.Stat(t.Cert); err == nil && !force {
+		fail(exitConfigError, "client certificate %s already exists, use --force to replace it", t.Cert)
+	}
+	notAfter, err := enroll(t, api, token, name, C.HTTPTimeout)
+	if err != nil {
+		fail(exitFailure, "error enrolling: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Enrolled %s, certificate %s valid until %s\n", name, t.Cert, notAfter.Format(time.RFC3339))
+}
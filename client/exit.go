@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+
+	"github.com/jbvmio/scriptrunner"
+	"go.uber.org/zap"
+)
+
+// Exit codes of the client.
+const (
+	// exitSuccess means no archive or script failed.
+	exitSuccess = 0
+	// exitPartialFailure means some archives or scripts failed while others succeeded.
+	exitPartialFailure = 1
+	// exitFailure means archives or scripts failed and none succeeded, or the run could not be performed.
+	exitFailure = 2
+	// exitConfigError means the client could not be configured and nothing was run.
+	exitConfigError = 3
+	// exitLocked means another instance of the client holds the lock and nothing was run.
+	exitLocked = 4
+)
+
+// exitStatus returns the exit code for the given report. Skipped and canceled archives
+// count as neither success nor failure, and a nil report is a failure.
+func exitStatus(report *scriptrunner.RunReport) int {
+	if report == nil {
+		return exitFailure
+	}
+	var succeeded, failed int
+	for _, a := range report.Archives {
+		switch a.Status {
+		case scriptrunner.StatusSucceeded:
+			succeeded++
+		case scriptrunner.StatusFailed, scriptrunner.StatusDependencyFailed:
+			failed++
+			for _, s := range a.Scripts {
+				if s.Status == scriptrunner.StatusSucceeded {
+					succeeded++
+				}
+			}
+		}
+	}
+	switch {
+	case failed == 0:
+		return exitSuccess
+	case succeeded == 0:
+		return exitFailure
+	}
+	return exitPartialFailure
+}
+
+// fatalConfig logs the configuration error and exits with exitConfigError.
+func fatalConfig(L *zap.Logger, msg string, fields ...zap.Field) {
+	L.Error(msg, fields...)
+	L.Sync()
+	os.Exit(exitConfigError)
+}
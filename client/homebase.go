@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jbvmio/scriptrunner"
+	"go.uber.org/zap"
+)
+
+const (
+	indexPath = `/index/`
+	filesPath = `/files/`
+)
+
+// certLoader provides the client certificate for each TLS handshake, reloading it once the certificate file changes
+// so that renewed certificates are used without a restart.
+type certLoader struct {
+	certFile string
+	keyFile  string
+	lock     sync.Mutex
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+// load reads the certificate and key if the certificate file changed since it was last loaded.
+func (c *certLoader) load() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	info, err := os.Stat(c.certFile)
+	if err != nil {
+		return fmt.Errorf("error reading client certificate %q: %w", c.certFile, err)
+	}
+	if c.cert != nil && info.ModTime().Equal(c.modTime) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("error loading client certificate %q and key %q: %w", c.certFile, c.keyFile, err)
+	}
+	c.cert, c.modTime = &cert, info.ModTime()
+	return nil
+}
+
+// get returns the current client certificate, keeping the previous one if a changed certificate cannot be loaded.
+func (c *certLoader) get(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.load()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.cert, nil
+}
+
+// newHTTPClient returns an http.Client authenticating with the configured client certificate and
+// verifying HomeBase against the configured CA certificate.
+func newHTTPClient(t scriptrunner.TLSConfig, timeout time.Duration) (*http.Client, error) {
+	cert := &certLoader{
+		certFile: t.Cert,
+		keyFile:  t.Key,
+	}
+	if err := cert.load(); err != nil {
+		return nil, err
+	}
+	return newTLSClient(t, timeout, cert.get)
+}
+
+// newTLSClient returns an http.Client verifying HomeBase against the configured CA certificate and
+// authenticating with the client certificate returned by getCert, if not nil. Clients enroll without a certificate.
+func newTLSClient(t scriptrunner.TLSConfig, timeout time.Duration, getCert func(*tls.CertificateRequestInfo) (*tls.Certificate, error)) (*http.Client, error) {
+	minVersion, err := t.Version()
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := ioutil.ReadFile(t.CACert)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA certificate %q: %w", t.CACert, err)
+	}
+	caCertPool := x509.NewCertPool()
+	if !caCertPool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("no certificates found in %q", t.CACert)
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				GetClientCertificate: getCert,
+				RootCAs:              caCertPool,
+				ServerName:           t.ServerName,
+				MinVersion:           minVersion,
+			},
+		},
+	}, nil
+}
+
+// fetchArchives lists the archives available from the HomeBase file server within remoteDir and
+// downloads those which are new or changed into the scripts directory. The names of the downloaded archives are returned.
+func fetchArchives(client *http.Client, homeBase, remoteDir, scripts string, L *zap.Logger) ([]string, error) {
+	remoteDir = strings.Trim(path.Clean(`/`+remoteDir), `/`)
+	if remoteDir != "" {
+		remoteDir += `/`
+	}
+	var index []scriptrunner.RemoteFile
+	if err := getJSON(client, strings.TrimRight(homeBase, `/`)+indexPath+remoteDir, &index); err != nil {
+		return nil, fmt.Errorf("error retrieving archive index: %w", err)
+	}
+	var fetched []string
+	var errs []string
+	for _, f := range index {
+		if !strings.EqualFold(path.Ext(f.Name), `.zip`) || f.Name != path.Base(f.Name) {
+			continue
+		}
+		local := filepath.Join(scripts, f.Name)
+		if hash, err := scriptrunner.FileHash(local); err == nil && hash == f.SHA256 {
+			L.Debug("archive unchanged", zap.String("archive", f.Name))
+			continue
+		}
+		U := strings.TrimRight(homeBase, `/`) + filesPath + remoteDir + url.PathEscape(f.Name)
+		if err := download(client, U, local, f.SHA256); err != nil {
+			errs = append(errs, err.Error())
+			L.Error("error downloading archive", zap.String("archive", f.Name), zap.Error(err))
+			continue
+		}
+		L.Info("downloaded archive", zap.String("archive", f.Name), zap.Int64("bytes", f.Size))
+		fetched = append(fetched, f.Name)
+	}
+	if len(errs) > 0 {
+		return fetched, fmt.Errorf("error downloading %d archives: %s", len(errs), strings.Join(errs, `; `))
+	}
+	return fetched, nil
+}
+
+// download retrieves the given URL into a temporary file, verifies its SHA-256 hash and moves it to dst.
+func download(client *http.Client, U, dst, sha256 string) error {
+	resp, err := client.Get(U)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), `.`+filepath.Base(dst)+`.*`)
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, resp.Body)
+	tmp.Close()
+	if err != nil {
+		return fmt.Errorf("error writing temporary file: %w", err)
+	}
+	hash, err := scriptrunner.FileHash(tmp.Name())
+	if err != nil {
+		return fmt.Errorf("error hashing download: %w", err)
+	}
+	if hash != sha256 {
+		return fmt.Errorf("hash mismatch for download: expected %s, got %s", sha256, hash)
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// getJSON retrieves the given URL and decodes the JSON response into obj.
+func getJSON(client *http.Client, U string, obj interface{}) error {
+	resp, err := client.Get(U)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(obj)
+}
+
+// postJSON posts obj as JSON to the given URL and returns the response status code.
+func postJSON(client *http.Client, U string, obj interface{}) (int, error) {
+	return exchangeJSON(client, U, obj, nil)
+}
+
+// exchangeJSON posts obj as JSON to the given URL, decodes the JSON response into out, if not nil,
+// and returns the response status code.
+func exchangeJSON(client *http.Client, U string, obj, out interface{}) (int, error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return 0, fmt.Errorf("error marshaling request: %w", err)
+	}
+	resp, err := client.Post(U, `application/json`, bytes.NewReader(b))
+	if err != nil {
+		return 0, fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		raw, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, fmt.Errorf("unexpected response status %s: %s", resp.Status, bytes.TrimSpace(raw))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("error decoding response: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
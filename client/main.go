@@ -1,12 +1,17 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"crypto/ed25519"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
+	_ "time/tzdata"
 
 	"github.com/jbvmio/scriptrunner"
-	"github.com/jbvmio/scriptrunner/powershell"
 	"github.com/spf13/pflag"
 	"go.uber.org/zap"
 )
@@ -16,61 +21,201 @@ const (
 	scriptsDir   = `scripts`
 	workspaceDir = `workspace`
 	certsDir     = `certs`
+	caCert       = `ca.crt`
+	clientCert   = `client.crt`
+	clientKey    = `client.key`
+	stateFile    = `state.json`
+	lockFile     = `scriptrunner.lock`
+	queueDir     = `queue`
+
+	defaultInterval = 15 * time.Minute
 )
 
 var (
 	config         string
 	homeBaseURL    string
+	apiURL         string
 	caCertFile     string
 	clientCertFile string
 	clientKeyFile  string
+	concurrency    int
+	keepFailed     bool
+	daemon         bool
+	interval       time.Duration
+	splay          time.Duration
+	dryRun         bool
+	output         string
+	reportFormat   string
+	reportFile     string
+	outputDir      string
+	failurePolicy  string
+	wait           bool
 	buildTime      string
 	commitHash     string
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case `pack`:
+			runPack(os.Args[2:])
+			return
+		case `config`:
+			runConfig(os.Args[2:])
+			return
+		case `secrets`:
+			runSecrets(os.Args[2:])
+			return
+		case `health`:
+			runHealth(os.Args[2:])
+			return
+		case `release`:
+			runRelease(os.Args[2:])
+			return
+		case `enroll`:
+			runEnroll(os.Args[2:])
+			return
+		}
+	}
+
 	pf := pflag.NewFlagSet("scriptrunner", pflag.ExitOnError)
 	pf.StringVarP(&config, `config`, `c`, "", "Path of Config File to Use, Overwriting Defaults.")
 	pf.StringVarP(&homeBaseURL, `homebase`, `h`, "", "Alternate HomeBase URL to use, Overwrites Config HomeBase Value.")
+	pf.StringVar(&apiURL, `api`, "", "Alternate HomeBase API URL to use for Reports, Overwrites Config HomeBaseAPI Value.")
+	pf.StringVar(&caCertFile, `cacert`, "", "Filepath to HomeBase Signing Certificate CA. Defaults to ca.crt within the Certs Directory.")
+	pf.StringVar(&clientCertFile, `cert`, "", "Filepath to Client Certificate. Defaults to client.crt within the Certs Directory.")
+	pf.StringVar(&clientKeyFile, `key`, "", "Filepath to Client Key. Defaults to client.key within the Certs Directory.")
+	pf.IntVarP(&concurrency, `concurrency`, `n`, 0, "Number of Archives to Run in Parallel, Overwrites Config Concurrency Value.")
+	pf.BoolVar(&keepFailed, `keep-failed`, false, "Preserve the Workspaces of Failed Archives, Overwrites Config KeepFailed Value.")
+	pf.BoolVar(&daemon, `daemon`, false, "Run Continuously, Fetching, Running and Reporting Archives Every Interval.")
+	pf.DurationVar(&interval, `interval`, 0, "Daemon: Interval between Cycles, Overwrites Config Interval Value. (default 15m)")
+	pf.DurationVar(&splay, `splay`, 0, "Daemon: Maximum Random Delay Added to Each Interval, Overwrites Config Splay Value.")
+	pf.BoolVar(&dryRun, `dry-run`, false, "Print the Plan for the Local Archives without Fetching, Running or Reporting Anything.")
+	pf.StringVar(&output, `output`, `table`, "Dry Run: Format of the Plan, table or json.")
+	pf.StringVar(&reportFormat, `report-format`, "", "Write a Report of Each Run as json or junit, Overwrites Config ReportFormat Value.")
+	pf.StringVar(&reportFile, `report-file`, "", "Filepath to Write the Report to, Overwrites Config ReportFile Value. (default stdout)")
+	pf.StringVar(&outputDir, `output-dir`, "", "Directory to Save the Full Output of Each Script, Overwrites Config OutputDir Value.")
+	pf.StringVar(&failurePolicy, `failure-policy`, "", "Action after a Script Fails: continue, stopArchive or stopAll, Overwrites Config FailurePolicy Value.")
+	pf.BoolVar(&wait, `wait`, false, "Wait for a Running Instance to Finish instead of Exiting.")
 	pf.Parse(os.Args[1:])
 
-	l := scriptrunner.ConfigureLogger(scriptrunner.ConfigureLevel(`info`), os.Stdout)
+	// Keep stdout for the plan or report when either is written there.
+	logOut := os.Stdout
+	if dryRun || (reportFormat != "" && reportFile == "") {
+		logOut = os.Stderr
+	}
+	l := scriptrunner.ConfigureLogger(scriptrunner.ConfigureLevel(`info`), logOut)
 	L := l.With(zap.String(`process`, `scriptrunner`))
 	L.Info("Starting ...", zap.String(`Version`, buildTime), zap.String(`Commit`, commitHash))
 
+	hostname, err := os.Hostname()
+	if err != nil {
+		L.Error("error retrieving hostname", zap.Error(err))
+	}
+
 	cwd, err := scriptrunner.GetCWD()
 	if err != nil {
-		L.Fatal("error retrieving cwd", zap.Error(err))
+		fatalConfig(L, "error retrieving cwd", zap.Error(err))
 	}
 	configPath := filepath.Join(cwd, configFile)
 	if config != "" {
 		configPath = config
 	}
+	config, found, err := loadConfig(configPath, pf.Changed(`config`))
+	if err != nil {
+		fatalConfig(L, "error retrieving config", zap.String("file", configPath), zap.Error(err))
+	}
+	if !found {
+		L.Warn("config file not found, using defaults", zap.String("file", configPath))
+		configPath = ""
+	}
+	applyDefaults(config)
+	if homeBaseURL == "" {
+		homeBaseURL = config.HomeBase
+	}
+	if apiURL == "" {
+		apiURL = config.HomeBaseAPI
+	}
+	if concurrency == 0 {
+		concurrency = config.Concurrency
+	}
+	if !pf.Changed(`keep-failed`) {
+		keepFailed = config.KeepFailed
+	}
+	if interval == 0 {
+		interval = config.Interval
+	}
+	if splay == 0 {
+		splay = config.Splay
+	}
+	if failurePolicy == "" {
+		failurePolicy = string(config.FailurePolicy)
+	}
+	if reportFormat == "" {
+		reportFormat = string(config.ReportFormat)
+	}
+	if reportFile == "" {
+		reportFile = resolvePath(cwd, config.ReportFile)
+	}
+	if outputDir == "" {
+		outputDir = resolvePath(cwd, config.OutputDir)
+	}
+	if reportFile != "" && reportFormat == "" {
+		reportFormat = string(scriptrunner.ReportJSON)
+	}
+	if reportFormat != "" && !scriptrunner.ReportFormat(reportFormat).Valid() {
+		fatalConfig(L, "invalid report format", zap.String("format", reportFormat))
+	}
+	if !scriptrunner.FailurePolicy(failurePolicy).Valid() {
+		fatalConfig(L, "invalid failure policy", zap.String("failurePolicy", failurePolicy))
+	}
+	if reportFormat != "" && reportFile == "" {
+		logOut = os.Stderr
+	}
+	scrubber := &scriptrunner.Scrubber{}
+	logger, err := newLogger(config.LogLevel, resolvePath(cwd, config.LogFile), logOut, scrubber)
+	if err != nil {
+		fatalConfig(L, "error configuring logger", zap.Error(err))
+	}
+	L = logger
 
-	scripts := filepath.Join(cwd, scriptsDir)
-	workspace := filepath.Join(cwd, workspaceDir)
-	certs := filepath.Join(cwd, certsDir)
-	config, err := scriptrunner.GetConfig(configPath)
-	switch {
-	case err != nil:
-		L.Error("error retrieving config", zap.Error(err))
-	default:
-		if config.ScriptsDir != "" {
-			scripts = filepath.Join(cwd, config.ScriptsDir)
-		}
-		if config.WorkspaceDir != "" {
-			workspace = filepath.Join(cwd, config.WorkspaceDir)
+	scripts := resolvePath(cwd, config.ScriptsDir)
+	workspace := resolvePath(cwd, config.WorkspaceDir)
+	certs := resolvePath(cwd, config.CertsDir)
+	statePath := resolvePath(cwd, config.StateFile)
+	lockPath := resolvePath(cwd, config.LockFile)
+	var schedules map[string]scriptrunner.ScheduleSpec
+	if config.ScheduleFile != "" {
+		schedulePath := resolvePath(cwd, config.ScheduleFile)
+		schedules, err = scriptrunner.LoadSchedules(schedulePath)
+		if err != nil {
+			fatalConfig(L, "error loading schedules", zap.Error(err))
 		}
-		if config.CertsDir != "" {
-			certs = filepath.Join(cwd, config.CertsDir)
+		L.Info("schedules loaded", zap.String("file", schedulePath), zap.Int("schedules", len(schedules)))
+	}
+	var verifyKey ed25519.PublicKey
+	if config.VerifyKey != "" {
+		keyPath := resolvePath(cwd, config.VerifyKey)
+		verifyKey, err = scriptrunner.LoadVerifyKey(keyPath)
+		if err != nil {
+			fatalConfig(L, "error loading archive verify key", zap.Error(err))
 		}
-		if homeBaseURL == "" {
-			homeBaseURL = config.HomeBase
+		L.Info("archive signatures required", zap.String("key", keyPath))
+	}
+	var updateKey ed25519.PublicKey
+	if config.Update.Enabled && apiURL != "" {
+		keyPath := resolvePath(cwd, config.Update.VerifyKey)
+		updateKey, err = scriptrunner.LoadVerifyKey(keyPath)
+		if err != nil {
+			fatalConfig(L, "error loading update verify key", zap.Error(err))
 		}
+		L.Info("client updates enabled", zap.String("key", keyPath))
 	}
 	L.Info("scripts directory", zap.String("directory", scripts))
 	L.Info("workspace directory", zap.String("directory", workspace))
 	L.Info("certs directory", zap.String("directory", certs))
+	L.Info("state file", zap.String("file", statePath))
+	L.Info("archive concurrency", zap.Int("concurrency", concurrency))
 
 	for _, d := range []string{scripts, workspace, certs} {
 		if err := scriptrunner.CreateDir(d); err != nil {
@@ -78,50 +223,137 @@ func main() {
 		}
 	}
 
-	files, err := scriptrunner.GetArchiveFiles(scripts)
-	if err != nil {
-		L.Fatal("error retrieving scripts directory", zap.String("directory", scripts), zap.Error(err))
-	}
-	L.Info("script archives discovered", zap.Int("archives", len(files)), zap.Strings("scripts", files))
-
-	pwsh := powershell.New(workspace)
-	for _, f := range files {
-		archive := filepath.Join(scripts, f)
-		L.Info("processing archive", zap.String("archive", f))
-		scriptrunner.UnZip(archive, workspace)
-
-		scripts, err := scriptrunner.GetDirFiles(workspace)
-		switch {
-		case err != nil:
-			L.Error("error list workspace", zap.Error(err))
-		default:
-			for _, script := range scripts {
-				if !script.IsDir {
-					L.Info("executing script", zap.String("script", script.FullPath))
-					stdOut, stdErr, err := pwsh.Execute(script.FullPath)
-					switch {
-					case err != nil:
-						var errMsg string
-						if stdErr != "" {
-							errMsg += stdErr + `; `
-						}
-						errMsg += err.Error()
-						L.Error("error running script", zap.String("script", script.Name), zap.String(`error`, errMsg))
-					case stdErr != "":
-						if stdOut != "" {
-							fmt.Println(stdOut)
-						}
-						L.Error("error running script", zap.String("script", script.Name), zap.String(`error`, stdErr))
-					case stdOut != "":
-						fmt.Println(stdOut)
-					}
-				}
-			}
+	tlsConfig := resolveTLS(cwd, config.TLS, caCertFile, clientCertFile, clientKeyFile)
+	_, err = os.Stat(tlsConfig.Cert)
+	enrolling := os.IsNotExist(err) && config.EnrollToken != "" && apiURL != "" && !dryRun
+	var client *http.Client
+	if (homeBaseURL != "" || apiURL != "") && !enrolling {
+		client, err = newHTTPClient(tlsConfig, config.HTTPTimeout)
+		if err != nil {
+			L.Error("error configuring HomeBase client", zap.Error(err))
 		}
-		err = scriptrunner.CleanDirectory(workspace)
+	}
+
+	var queue *scriptrunner.ReportQueue
+	if apiURL != "" && !dryRun {
+		config.ReportQueue.Dir = resolvePath(cwd, config.ReportQueue.Dir)
+		queue, err = scriptrunner.NewReportQueue(config.ReportQueue)
 		if err != nil {
-			L.Error("error cleaning workspace", zap.Error(err))
+			L.Error("error creating report queue, reports will not be queued", zap.Error(err))
+		}
+	}
+
+	state, err := scriptrunner.LoadState(statePath)
+	if err != nil {
+		fatalConfig(L, "error loading state", zap.String("file", statePath), zap.Error(err))
+	}
+
+	A := agent{
+		logger:      L,
+		hostname:    hostname,
+		scripts:     scripts,
+		workspace:   workspace,
+		homeBaseURL: homeBaseURL,
+		apiURL:      apiURL,
+		remoteDir:   config.RemoteDir,
+		client:      client,
+		state:       state,
+		schedules:   schedules,
+		windows:     config.MaintenanceWindows,
+		verifyKey:   verifyKey,
+		keepFailed:  keepFailed,
+		concurrency: concurrency,
+		retention:   config.Retention,
+		labels:      config.Labels,
+		vars:        config.Vars,
+		secretsFile: resolvePath(cwd, config.SecretsFile),
+		secretKey:   tlsConfig.Key,
+		scrubber:    scrubber,
+		queue:       queue,
+		tls:         tlsConfig,
+		configPath:  configPath,
+
+		updateKey:     updateKey,
+		updateState:   filepath.Join(cwd, updateStateFile),
+		healthTimeout: config.Update.HealthTimeout,
+		renewAfter:    config.TLS.RenewAfter,
+		outputDir:     outputDir,
+		out:           logOut,
+
+		reportFormat: scriptrunner.ReportFormat(reportFormat),
+		reportFile:   reportFile,
+		onFailure:    scriptrunner.FailurePolicy(failurePolicy),
+		powershell:   executorPath(cwd, config.Executors.PowerShell),
+		timeout:      config.ScriptTimeout,
+		status:       scriptrunner.ClientIdle,
+	}
+	if dryRun {
+		A.scheduled = daemon
+		if err := A.runDryRun(output); err != nil {
+			L.Error("error planning run", zap.Error(err))
+			os.Exit(exitFailure)
 		}
+		return
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigChan
+		signal.Stop(sigChan)
+		L.Info("Stopping after current scripts finish, signal again to force ...", zap.String("signal", sig.String()))
+		cancel()
+	}()
+
+	lock, err := scriptrunner.AcquireLock(lockPath)
+	if locked, ok := err.(*scriptrunner.LockedError); ok && wait {
+		L.Info("waiting for running instance", zap.String("lock", lockPath), zap.Int("pid", locked.Info.PID), zap.Time("started", locked.Info.Started))
+		lock, err = scriptrunner.WaitLock(ctx, lockPath, time.Second)
+	}
+	if err != nil {
+		L.Error("error acquiring lock, another instance may be running", zap.String("lock", lockPath), zap.Error(err))
+		L.Sync()
+		os.Exit(exitLocked)
+	}
+	L.Info("lock acquired", zap.String("lock", lockPath))
+	if enrolling {
+		A.enrollClient(config.EnrollToken, config.HTTPTimeout)
+	}
+
+	A.checkCert()
+	if err := A.register(); err != nil {
+		L.Error("error registering with HomeBase", zap.String("api", apiURL), zap.Error(err))
+	}
+
+	code := exitSuccess
+	switch {
+	case daemon:
+		if interval <= 0 {
+			interval = defaultInterval
+		}
+		go A.runHeartbeats(ctx, config.Heartbeat)
+		A.runDaemon(ctx, interval, splay)
+	default:
+		code = exitStatus(A.runOnce(ctx))
+		A.selfUpdate()
+	}
+	A.setStatus(scriptrunner.ClientStopped, "")
+	A.heartbeat()
+	cancel()
+	if err := lock.Release(); err != nil {
+		L.Error("error releasing lock", zap.String("lock", lockPath), zap.Error(err))
+	}
+	L.Info("Stopped.", zap.Int("exitCode", code))
+	if A.restartExe != "" {
+		L.Info("Restarting ...", zap.String("executable", A.restartExe))
+		L.Sync()
+		if err := restart(A.restartExe); err != nil {
+			L.Error("error restarting", zap.Error(err))
+			code = exitFailure
+		}
+	}
+	L.Sync()
+	os.Exit(code)
 }
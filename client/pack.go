@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/jbvmio/scriptrunner"
+	"github.com/spf13/pflag"
+)
+
+// runPack builds an archive from a script directory.
+func runPack(args []string) {
+	var out, signKey string
+	pf := pflag.NewFlagSet("scriptrunner pack", pflag.ExitOnError)
+	pf.StringVarP(&out, `out`, `o`, "", "Filepath of the Archive to Create. Defaults to <directory>.zip.")
+	pf.StringVar(&signKey, `sign-key`, "", "Sign the Archive using the Given PEM ed25519 Private Key (openssl genpkey -algorithm ed25519).")
+	pf.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: scriptrunner pack [flags] <directory>\n")
+		pf.PrintDefaults()
+	}
+	pf.Parse(args)
+	if pf.NArg() != 1 {
+		pf.Usage()
+		os.Exit(2)
+	}
+	dir := pf.Arg(0)
+	if out == "" {
+		out = filepath.Base(filepath.Clean(dir)) + `.zip`
+	}
+
+	var key ed25519.PrivateKey
+	var err error
+	if signKey != "" {
+		key, err = scriptrunner.LoadSigningKey(signKey)
+		if err != nil {
+			log.Fatalf("error loading signing key: %v\n", err)
+		}
+	}
+	m, err := scriptrunner.PackArchive(dir, out, key)
+	if err != nil {
+		log.Fatalf("error packing %q: %v\n", dir, err)
+	}
+	log.Printf("Packed %d files (%d scripts) from %s to %s, signed: %v\n", len(m.Files), len(m.Scripts), dir, out, key != nil)
+}
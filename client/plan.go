@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jbvmio/scriptrunner"
+	"github.com/jbvmio/scriptrunner/powershell"
+	"go.uber.org/zap"
+)
+
+// Plan actions.
+const (
+	actionRun  = `run`
+	actionSkip = `skip`
+)
+
+// runPlan describes what a run would do without running anything.
+type runPlan struct {
+	Host      string            `json:"host"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Generated time.Time         `json:"generated"`
+	Archives  []*archivePlan    `json:"archives"`
+}
+
+// archivePlan describes what a run would do with an archive.
+type archivePlan struct {
+	Order        int                    `json:"order,omitempty"`
+	Archive      string                 `json:"archive"`
+	Name         string                 `json:"name,omitempty"`
+	Action       string                 `json:"action"`
+	Status       scriptrunner.Status    `json:"status,omitempty"`
+	Reason       string                 `json:"reason,omitempty"`
+	Verification string                 `json:"verification,omitempty"`
+	Exclusive    bool                   `json:"exclusive,omitempty"`
+	RunPolicy    scriptrunner.RunPolicy `json:"runPolicy,omitempty"`
+	Selector     string                 `json:"selector,omitempty"`
+	Schedule     string                 `json:"schedule,omitempty"`
+	DependsOn    []string               `json:"dependsOn,omitempty"`
+	Scripts      []*scriptPlan          `json:"scripts,omitempty"`
+}
+
+// scriptPlan describes a script an archive would run.
+type scriptPlan struct {
+	Order     int      `json:"order"`
+	Script    string   `json:"script"`
+	Executor  string   `json:"executor"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// plan discovers, validates and verifies the local archives and resolves what a run would do with them.
+// Archives are extracted to a temporary directory to resolve their scripts, but nothing is executed,
+// fetched or recorded.
+func (a *agent) plan() (*runPlan, error) {
+	files, err := scriptrunner.GetArchiveFiles(a.scripts)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving scripts directory: %w", err)
+	}
+	tmp, err := ioutil.TempDir(a.workspace, `plan-`)
+	if err != nil {
+		return nil, fmt.Errorf("error creating plan directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	started := time.Now()
+	facts := a.checkIn()
+	R := runner{
+		state:      a.state,
+		schedules:  a.schedules,
+		windows:    a.windows,
+		labels:     facts.SelectorLabels(),
+		facts:      &facts.HostFacts,
+		vars:       a.vars,
+		scheduled:  a.scheduled,
+		since:      a.lastCycle,
+		started:    started,
+		verifyKey:  a.verifyKey,
+		powershell: a.powershell,
+		logger:     a.logger,
+	}
+	P := runPlan{
+		Host:      a.hostname,
+		Labels:    R.labels,
+		Generated: started.UTC(),
+	}
+
+	archives := loadArchives(a.scripts, files, a.logger)
+	loaded := make(map[string]bool, len(archives))
+	for _, ar := range archives {
+		loaded[ar.File] = true
+	}
+	for _, f := range files {
+		if loaded[f] {
+			continue
+		}
+		_, err := scriptrunner.ReadManifest(filepath.Join(a.scripts, f))
+		P.Archives = append(P.Archives, &archivePlan{
+			Archive: f,
+			Action:  actionSkip,
+			Status:  scriptrunner.StatusFailed,
+			Reason:  fmt.Sprintf("invalid archive: %v", err),
+		})
+	}
+
+	byName, g := R.archiveGraph(archives)
+	order, err := g.Sort()
+	statuses := make(map[string]scriptrunner.Status, len(byName))
+	if cycle, ok := err.(*scriptrunner.CycleError); ok {
+		for _, n := range cycle.Nodes {
+			statuses[n] = scriptrunner.StatusDependencyFailed
+			p := R.planArchive(byName[n], tmp)
+			p.Action, p.Status, p.Reason = actionSkip, scriptrunner.StatusDependencyFailed, "dependency cycle"
+			P.Archives = append(P.Archives, p)
+		}
+	}
+	for i, n := range order {
+		ar := byName[n]
+		p := R.planArchive(ar, tmp)
+		p.Order = i + 1
+		if p.Action == actionRun {
+			if _, reason := dependencyState(g, n, statuses); reason != "" {
+				p.Action, p.Status, p.Reason = actionSkip, scriptrunner.StatusDependencyFailed, reason
+			} else if status, reason := R.skipReason(ar); status != "" {
+				p.Action, p.Status, p.Reason = actionSkip, status, reason
+			}
+		}
+		switch p.Action {
+		case actionRun:
+			statuses[n] = scriptrunner.StatusSucceeded
+		default:
+			statuses[n] = R.dependencyStatus(ar, p.Status)
+		}
+		P.Archives = append(P.Archives, p)
+	}
+	return &P, nil
+}
+
+// planArchive verifies the archive and resolves its scripts, their order and executor.
+func (r *runner) planArchive(a *archive, tmp string) *archivePlan {
+	P := archivePlan{
+		Archive:   a.File,
+		Name:      a.Name(),
+		Action:    actionRun,
+		Exclusive: a.Manifest.Exclusive,
+		RunPolicy: a.Manifest.RunPolicy,
+		Selector:  a.Manifest.Selector,
+		DependsOn: a.Manifest.DependsOn,
+	}
+	if s := r.schedule(a); s != nil {
+		P.Schedule = s.Cron
+		if s.Timezone != "" {
+			P.Schedule += ` (` + s.Timezone + `)`
+		}
+	}
+	fail := func(msg string, err error) *archivePlan {
+		P.Action, P.Status, P.Reason = actionSkip, scriptrunner.StatusFailed, msg+": "+err.Error()
+		return &P
+	}
+
+	m, err := scriptrunner.VerifyArchive(a.Path, r.verifyKey)
+	if err != nil {
+		return fail("error verifying archive", err)
+	}
+	a = a.verified(m)
+	switch {
+	case r.verifyKey != nil:
+		P.Verification = `signature`
+	case len(a.Manifest.Files) > 0:
+		P.Verification = `hashes`
+	default:
+		P.Verification = `none`
+	}
+	dir := filepath.Join(tmp, a.File)
+	defer os.RemoveAll(dir)
+	if err := scriptrunner.UnZip(a.Path, dir); err != nil {
+		return fail("error extracting archive", err)
+	}
+	names, err := r.prepareScripts(a, dir)
+	if err != nil {
+		return fail("error preparing scripts", err)
+	}
+	g := a.Manifest.ScriptGraph(names)
+	order, err := g.Sort()
+	if err != nil {
+		return fail("error ordering scripts", err)
+	}
+	executor := powershell.NewWithPath(r.powershell, dir).Path()
+	if executor == "" {
+		executor = `powershell.exe (not found)`
+	}
+	for i, name := range order {
+		P.Scripts = append(P.Scripts, &scriptPlan{
+			Order:     i + 1,
+			Script:    name,
+			Executor:  executor,
+			DependsOn: g.Dependencies(name),
+		})
+	}
+	return &P
+}
+
+// writePlan writes the plan to w in the given format, either table or json.
+func writePlan(w io.Writer, P *runPlan, format string) error {
+	switch format {
+	case `json`:
+		b, err := json.MarshalIndent(P, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error marshaling plan: %w", err)
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	case `table`, "":
+	default:
+		return fmt.Errorf("invalid output format %q", format)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ORDER\tARCHIVE\tSCRIPT\tACTION\tEXECUTOR\tDETAILS")
+	for _, a := range P.Archives {
+		order := `-`
+		if a.Order > 0 {
+			order = strconv.Itoa(a.Order)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t\t%s\t\t%s\n", order, a.Archive, a.Action, archiveDetails(a))
+		for _, s := range a.Scripts {
+			var details string
+			if len(s.DependsOn) > 0 {
+				details = `dependsOn=` + strings.Join(s.DependsOn, `,`)
+			}
+			fmt.Fprintf(tw, "%s.%d\t\t%s\t\t%s\t%s\n", order, s.Order, s.Script, s.Executor, details)
+		}
+	}
+	return tw.Flush()
+}
+
+// archiveDetails summarizes the options and skip reason of an archive for the plan table.
+func archiveDetails(a *archivePlan) string {
+	var details []string
+	if a.Reason != "" {
+		details = append(details, string(a.Status)+`: `+a.Reason)
+	}
+	if a.Name != "" && a.Name != scriptrunner.ArchiveName(a.Archive) {
+		details = append(details, `name=`+a.Name)
+	}
+	if a.Verification != "" {
+		details = append(details, `verification=`+a.Verification)
+	}
+	if a.Exclusive {
+		details = append(details, `exclusive`)
+	}
+	if a.RunPolicy != "" {
+		details = append(details, `runPolicy=`+string(a.RunPolicy))
+	}
+	if a.Selector != "" {
+		details = append(details, `selector=`+a.Selector)
+	}
+	if a.Schedule != "" {
+		details = append(details, `schedule=`+a.Schedule)
+	}
+	if len(a.DependsOn) > 0 {
+		details = append(details, `dependsOn=`+strings.Join(a.DependsOn, `,`))
+	}
+	return strings.Join(details, `; `)
+}
+
+// runDryRun writes the plan of the local archives to stdout in the given format.
+func (a *agent) runDryRun(format string) error {
+	P, err := a.plan()
+	if err != nil {
+		return err
+	}
+	a.logger.Info("dry run complete", zap.Int("archives", len(P.Archives)))
+	return writePlan(os.Stdout, P, format)
+}
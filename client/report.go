@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/jbvmio/scriptrunner"
+	"go.uber.org/zap"
+)
+
+const reportsPath = `/reports`
+
+// reporter sends run reports to the HomeBase API.
+type reporter struct {
+	client *http.Client
+	url    string
+}
+
+func newReporter(client *http.Client, api string) *reporter {
+	return &reporter{
+		client: client,
+		url:    strings.TrimRight(api, `/`) + reportsPath,
+	}
+}
+
+// Send posts the RunReport to HomeBase. A *scriptrunner.RejectedError is returned if HomeBase rejected the report.
+func (r *reporter) Send(report *scriptrunner.RunReport) error {
+	code, err := postJSON(r.client, r.url, report)
+	switch {
+	case err == nil:
+		return nil
+	case rejectedStatus(code):
+		return &scriptrunner.RejectedError{Err: fmt.Errorf("error sending report: %w", err)}
+	}
+	return fmt.Errorf("error sending report: %w", err)
+}
+
+// rejectedStatus returns true if the response status code shows that HomeBase will never accept the request.
+// Authentication failures, timeouts and rate limiting concern the client rather than the request and are retried.
+func rejectedStatus(code int) bool {
+	switch code {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return code >= 400 && code < 500
+}
+
+// deliverReport queues the report and sends every queued report to HomeBase in order.
+// Reports remain queued while HomeBase is unreachable and are sent by a later cycle or heartbeat.
+// Without a queue, the report is sent directly.
+func (a *agent) deliverReport(report *scriptrunner.RunReport, L *zap.Logger) {
+	if a.queue != nil {
+		err := a.queue.Push(report)
+		if err == nil {
+			a.flushReports()
+			return
+		}
+		L.Error("error queuing report", zap.Error(err))
+	}
+	switch err := newReporter(a.client, a.apiURL).Send(report); {
+	case err != nil:
+		L.Error("error sending report", zap.String("api", a.apiURL), zap.Error(err))
+	default:
+		L.Info("report sent", zap.String("api", a.apiURL))
+	}
+}
+
+// flushReports drops the queued reports exceeding the queue limits and sends the rest to HomeBase, oldest first.
+// The run cycle and heartbeats both flush the queue, so a flush is skipped while another is in progress;
+// reports queued meanwhile are sent by the next flush.
+func (a *agent) flushReports() {
+	if a.queue == nil || a.client == nil || a.apiURL == "" {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&a.flushing, 0, 1) {
+		a.logger.Debug("report queue is already being flushed")
+		return
+	}
+	defer atomic.StoreInt32(&a.flushing, 0)
+	L := a.logger
+	dropped, err := a.queue.Prune()
+	if err != nil {
+		L.Error("error pruning report queue", zap.Error(err))
+	}
+	if len(dropped) > 0 {
+		L.Warn("dropped queued reports exceeding queue limits", zap.Strings("runIDs", dropped))
+	}
+	sent, rejected, err := a.queue.Replay(newReporter(a.client, a.apiURL).Send)
+	if len(sent) > 0 {
+		L.Info("reports sent", zap.String("api", a.apiURL), zap.Strings("runIDs", sent))
+	}
+	if len(rejected) > 0 {
+		L.Error("queued reports rejected by HomeBase, moved to "+scriptrunner.RejectedDir+" within the queue directory", zap.String("api", a.apiURL), zap.Strings("runIDs", rejected))
+	}
+	if err != nil {
+		L.Warn("error sending queued reports, retrying later", zap.String("api", a.apiURL), zap.Int("queued", a.queue.Len()), zap.Error(err))
+	}
+}
+
+// summarize returns the Status of each archive result by archive name.
+func summarize(results []*scriptrunner.ArchiveResult) map[string]scriptrunner.Status {
+	summary := make(map[string]scriptrunner.Status, len(results))
+	for _, r := range results {
+		summary[r.Name] = r.Status
+	}
+	return summary
+}
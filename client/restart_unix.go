@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// restart replaces the current process with the given executable using the same arguments.
+func restart(exe string) error {
+	return syscall.Exec(exe, os.Args, os.Environ())
+}
@@ -0,0 +1,562 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jbvmio/scriptrunner"
+	"github.com/jbvmio/scriptrunner/powershell"
+	"go.uber.org/zap"
+)
+
+// archive contains details for a discovered script archive.
+type archive struct {
+	File     string
+	Path     string
+	Hash     string
+	Manifest *scriptrunner.Manifest
+}
+
+// Name returns the name other archives use to depend on the archive.
+func (a *archive) Name() string {
+	if a.Manifest.Name != "" {
+		return a.Manifest.Name
+	}
+	return scriptrunner.ArchiveName(a.File)
+}
+
+// verified returns a copy of the archive using the given Manifest returned by verification.
+func (a *archive) verified(m *scriptrunner.Manifest) *archive {
+	A := *a
+	A.Manifest = m
+	return &A
+}
+
+// runner processes archives within a run workspace.
+type runner struct {
+	ws         *scriptrunner.Workspace
+	state      *scriptrunner.State
+	schedules  map[string]scriptrunner.ScheduleSpec
+	windows    []scriptrunner.MaintenanceWindow
+	labels     map[string]string
+	facts      *scriptrunner.HostFacts
+	vars       map[string]string
+	secrets    map[string]string
+	scrubber   *scriptrunner.Scrubber
+	scheduled  bool
+	since      time.Time
+	started    time.Time
+	verifyKey  ed25519.PublicKey
+	keepFailed bool
+	onFailure  scriptrunner.FailurePolicy
+	powershell string
+	timeout    time.Duration
+	outputDir  string
+	out        io.Writer
+	logger     *zap.Logger
+	outLock    sync.Mutex
+	stopLock   sync.Mutex
+	stopReason string
+}
+
+// runArchives processes the given archives in dependency order using up to concurrency workers
+// and returns the result of each archive. Archives declaring themselves exclusive wait for all
+// other archives to finish and run alone. Archives whose dependencies did not succeed are skipped,
+// as are all pending archives once ctx is canceled.
+func (r *runner) runArchives(ctx context.Context, archives []*archive, concurrency int) []*scriptrunner.ArchiveResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	byName, g := r.archiveGraph(archives)
+
+	statuses := make(map[string]scriptrunner.Status, len(byName))
+	results := make([]*scriptrunner.ArchiveResult, 0, len(byName))
+	skip := func(a *archive, status scriptrunner.Status, reason string) {
+		statuses[a.Name()] = r.dependencyStatus(a, status)
+		results = append(results, &scriptrunner.ArchiveResult{
+			Archive: a.File,
+			Name:    a.Name(),
+			Status:  status,
+			Reason:  reason,
+		})
+		fields := []zap.Field{zap.String("archive", a.File), zap.String("status", string(status)), zap.String("reason", reason)}
+		switch status {
+		case scriptrunner.StatusDependencyFailed:
+			r.logger.Warn("skipping archive", fields...)
+		default:
+			r.logger.Info("skipping archive", fields...)
+		}
+	}
+
+	pending, err := g.Sort()
+	if cycle, ok := err.(*scriptrunner.CycleError); ok {
+		r.logger.Error("error ordering archives", zap.Error(err))
+		for _, n := range cycle.Nodes {
+			skip(byName[n], scriptrunner.StatusDependencyFailed, "dependency cycle")
+		}
+	}
+
+	done := make(chan *scriptrunner.ArchiveResult)
+	var running int
+	var exclusiveRunning bool
+	for len(pending) > 0 || running > 0 {
+		var next []string
+		var blocked bool
+		for _, n := range pending {
+			a := byName[n]
+			ready, reason := dependencyState(g, n, statuses)
+			switch canceled := r.canceled(ctx); {
+			case canceled != "":
+				skip(a, scriptrunner.StatusCanceled, canceled)
+			case reason != "":
+				skip(a, scriptrunner.StatusDependencyFailed, reason)
+			case !ready, blocked, exclusiveRunning, running >= concurrency:
+				next = append(next, n)
+			case a.Manifest.Exclusive && running > 0:
+				blocked = true
+				next = append(next, n)
+			default:
+				if status, reason := r.skipReason(a); status != "" {
+					skip(a, status, reason)
+					continue
+				}
+				running++
+				exclusiveRunning = a.Manifest.Exclusive
+				go func(a *archive) {
+					done <- r.runArchive(ctx, a)
+				}(a)
+			}
+		}
+		pending = next
+		if running > 0 {
+			res := <-done
+			running--
+			exclusiveRunning = false
+			statuses[res.Name] = res.Status
+			results = append(results, res)
+			if res.Status != scriptrunner.StatusCanceled {
+				r.recordState(byName[res.Name], res.Status)
+			}
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Archive < results[j].Archive
+	})
+	return results
+}
+
+// archiveGraph returns the archives by name and a Graph of the dependencies declared between them.
+// Archives reusing the name of an earlier archive are logged and left out.
+func (r *runner) archiveGraph(archives []*archive) (map[string]*archive, *scriptrunner.Graph) {
+	byName := make(map[string]*archive, len(archives))
+	g := scriptrunner.NewGraph()
+	for _, a := range archives {
+		if _, ok := byName[a.Name()]; ok {
+			r.logger.Error("duplicate archive name, skipping", zap.String("archive", a.File), zap.String("name", a.Name()))
+			continue
+		}
+		byName[a.Name()] = a
+		g.AddNode(a.Name())
+		for _, d := range a.Manifest.DependsOn {
+			g.AddDependency(a.Name(), d)
+		}
+	}
+	return byName, g
+}
+
+// skipReason returns the Status and reason for an archive that should not run, or an empty Status if it should.
+func (r *runner) skipReason(a *archive) (scriptrunner.Status, string) {
+	if a.Manifest.Selector != "" {
+		sel, err := scriptrunner.ParseSelector(a.Manifest.Selector)
+		switch {
+		case err != nil:
+			return scriptrunner.StatusFailed, err.Error()
+		case !sel.Matches(r.labels):
+			return scriptrunner.StatusSkipped, "selector " + sel.String() + " not matched: " + strings.Join(sel.Unmatched(r.labels), `, `)
+		}
+	}
+	prev, ok := r.state.Get(a.Name())
+	if a.Manifest.RunPolicy.Satisfied(a.Hash, prev, ok) {
+		return scriptrunner.StatusSatisfied, "runPolicy " + string(a.Manifest.RunPolicy) + " satisfied by run " + prev.RunID
+	}
+	if s := r.schedule(a); s != nil && r.scheduled {
+		due, next, err := s.Due(prev.LastRun, r.since, r.started)
+		switch {
+		case err != nil:
+			return scriptrunner.StatusFailed, "invalid schedule: " + err.Error()
+		case !due && next.IsZero():
+			return scriptrunner.StatusNotDue, "schedule " + s.Cron + " has no upcoming runs"
+		case !due:
+			return scriptrunner.StatusNotDue, "schedule " + s.Cron + " next due at " + next.Format(time.RFC3339)
+		}
+	}
+	if a.Manifest.RequiresMaintenanceWindow {
+		open, next, start := scriptrunner.NextMaintenanceWindow(r.windows, r.started)
+		switch {
+		case len(r.windows) == 0:
+			return scriptrunner.StatusDeferred, "requires a maintenance window but none are configured"
+		case open:
+		case next == nil:
+			return scriptrunner.StatusDeferred, "requires a maintenance window but none will open"
+		default:
+			return scriptrunner.StatusDeferred, "deferred until maintenance window " + next.Name + " opens at " + start.Format(time.RFC3339)
+		}
+	}
+	return "", ""
+}
+
+// schedule returns the ScheduleSpec of the archive from the central schedules, or from its manifest, if any.
+func (r *runner) schedule(a *archive) *scriptrunner.ScheduleSpec {
+	if s, ok := r.schedules[a.Name()]; ok {
+		return &s
+	}
+	return a.Manifest.Schedule
+}
+
+// dependencyStatus returns the Status used by dependents of an archive skipped with the given Status.
+// Archives not run in this cycle whose last run succeeded still satisfy their dependents.
+func (r *runner) dependencyStatus(a *archive, status scriptrunner.Status) scriptrunner.Status {
+	switch status {
+	case scriptrunner.StatusSatisfied, scriptrunner.StatusDependencyFailed, scriptrunner.StatusCanceled, scriptrunner.StatusFailed:
+		return status
+	}
+	if prev, ok := r.state.Get(a.Name()); ok && prev.Status == scriptrunner.StatusSucceeded {
+		return scriptrunner.StatusSatisfied
+	}
+	return status
+}
+
+// recordState stores the outcome of an archive run in the local state.
+func (r *runner) recordState(a *archive, status scriptrunner.Status) {
+	err := r.state.Record(a.Name(), scriptrunner.ArchiveState{
+		Hash:    a.Hash,
+		RunID:   r.ws.RunID,
+		LastRun: time.Now().UTC(),
+		Status:  status,
+	})
+	if err != nil {
+		r.logger.Error("error recording archive state", zap.String("archive", a.File), zap.Error(err))
+	}
+}
+
+// runArchive extracts the archive into its own workspace directory and executes its scripts in dependency order.
+// Once ctx is canceled, the remaining scripts are skipped and the archive is canceled.
+func (r *runner) runArchive(ctx context.Context, a *archive) *scriptrunner.ArchiveResult {
+	L := r.logger.With(zap.String("archive", a.File))
+	L.Info("processing archive", zap.Bool("exclusive", a.Manifest.Exclusive))
+	result := &scriptrunner.ArchiveResult{
+		Archive: a.File,
+		Name:    a.Name(),
+		Status:  scriptrunner.StatusFailed,
+		Started: time.Now().UTC(),
+	}
+	defer func() {
+		result.Duration = time.Since(result.Started)
+	}()
+	fail := func(msg string, err error) *scriptrunner.ArchiveResult {
+		L.Error(msg, zap.Error(err))
+		result.Reason = msg + ": " + err.Error()
+		return result
+	}
+
+	// Verify before creating the workspace so that no empty workspace is preserved for a rejected archive.
+	m, err := scriptrunner.VerifyArchive(a.Path, r.verifyKey)
+	if err != nil {
+		return fail("error verifying archive", err)
+	}
+	a = a.verified(m)
+	archiveDir, err := r.ws.ArchiveDir(a.File)
+	if err != nil {
+		return fail("error creating archive workspace", err)
+	}
+	defer func() {
+		r.cleanArchiveDir(a, result.Status, L)
+	}()
+	if err := scriptrunner.UnZip(a.Path, archiveDir); err != nil {
+		return fail("error extracting archive", err)
+	}
+
+	names, err := r.prepareScripts(a, archiveDir)
+	if err != nil {
+		return fail("error preparing scripts", err)
+	}
+	L.Info("scripts discovered", zap.Strings("scripts", names))
+	g := a.Manifest.ScriptGraph(names)
+	order, err := g.Sort()
+	if err != nil {
+		return fail("error ordering scripts", err)
+	}
+
+	env, secretsDir, err := r.secretEnv(a)
+	if secretsDir != "" {
+		defer func() {
+			if err := os.RemoveAll(secretsDir); err != nil {
+				L.Error("error removing secrets directory", zap.Error(err))
+			}
+		}()
+	}
+	if err != nil {
+		return fail("error preparing secrets", err)
+	}
+
+	pwsh := powershell.NewWithPath(r.powershell, archiveDir)
+	pwsh.SetTimeout(r.timeout)
+	pwsh.SetEnv(env)
+	result.Status = scriptrunner.StatusSucceeded
+	statuses := make(map[string]scriptrunner.Status, len(order))
+	var stopped string
+	for _, name := range order {
+		if stopped != "" {
+			result.Scripts = append(result.Scripts, &scriptrunner.ScriptResult{
+				Script: name,
+				Status: scriptrunner.StatusSkipped,
+				Reason: stopped,
+			})
+			continue
+		}
+		if canceled := r.canceled(ctx); canceled != "" {
+			if result.Status == scriptrunner.StatusSucceeded {
+				result.Status = scriptrunner.StatusCanceled
+				result.Reason = canceled
+			}
+			result.Scripts = append(result.Scripts, &scriptrunner.ScriptResult{
+				Script: name,
+				Status: scriptrunner.StatusCanceled,
+				Reason: canceled,
+			})
+			continue
+		}
+		if _, reason := dependencyState(g, name, statuses); reason != "" {
+			statuses[name] = scriptrunner.StatusDependencyFailed
+			result.Status = scriptrunner.StatusFailed
+			result.Scripts = append(result.Scripts, &scriptrunner.ScriptResult{
+				Script: name,
+				Status: scriptrunner.StatusDependencyFailed,
+				Reason: reason,
+			})
+			L.Warn("skipping script", zap.String("script", name), zap.String("status", string(scriptrunner.StatusDependencyFailed)), zap.String("reason", reason))
+			continue
+		}
+		sr := r.runScript(pwsh, a, archiveDir, name, L)
+		statuses[name] = sr.Status
+		result.Scripts = append(result.Scripts, sr)
+		if sr.Status != scriptrunner.StatusSucceeded {
+			result.Status = scriptrunner.StatusFailed
+			switch r.onFailure {
+			case scriptrunner.FailStopAll:
+				r.stop("failurePolicy stopAll after " + a.Name() + "/" + name + " failed")
+				fallthrough
+			case scriptrunner.FailStopArchive:
+				stopped = "failurePolicy " + string(r.onFailure) + " after " + name + " failed"
+				L.Warn("skipping remaining scripts", zap.String("reason", stopped))
+			}
+		}
+	}
+	if result.Status == scriptrunner.StatusCanceled {
+		L.Warn("archive canceled", zap.String("reason", result.Reason))
+	}
+	return result
+}
+
+// stop cancels all remaining scripts and archives for the given reason.
+func (r *runner) stop(reason string) {
+	r.stopLock.Lock()
+	defer r.stopLock.Unlock()
+	if r.stopReason == "" {
+		r.stopReason = reason
+		r.logger.Warn("stopping remaining archives", zap.String("reason", reason))
+	}
+}
+
+// canceled returns why remaining scripts and archives are canceled, or an empty string if they are not.
+func (r *runner) canceled(ctx context.Context) string {
+	if ctx.Err() != nil {
+		return "shutdown requested"
+	}
+	r.stopLock.Lock()
+	defer r.stopLock.Unlock()
+	return r.stopReason
+}
+
+// prepareScripts renders the templates within the extracted archive and returns the scripts it contains.
+// Scripts are also rendered as templates if the manifest enables templating.
+func (r *runner) prepareScripts(a *archive, archiveDir string) ([]string, error) {
+	data := scriptrunner.TemplateData{
+		Labels: r.labels,
+		Vars:   r.vars,
+		Params: a.Manifest.Params,
+		Run: scriptrunner.TemplateRun{
+			Archive: a.File,
+			Name:    a.Name(),
+			Dir:     archiveDir,
+		},
+	}
+	if r.facts != nil {
+		data.Host = *r.facts
+	}
+	if r.ws != nil {
+		data.Run.ID = r.ws.RunID
+	}
+	if _, err := scriptrunner.RenderTemplates(archiveDir, &data); err != nil {
+		return nil, err
+	}
+	names, err := scriptrunner.DiscoverScripts(archiveDir, a.Manifest.Include, a.Manifest.Exclude, a.Manifest.Recursive)
+	if err != nil {
+		return nil, fmt.Errorf("error discovering scripts: %w", err)
+	}
+	if a.Manifest.Template {
+		for _, name := range names {
+			path := filepath.Join(archiveDir, filepath.FromSlash(name))
+			if err := scriptrunner.RenderFile(path, path, &data); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return names, nil
+}
+
+// cleanArchiveDir removes the workspace directory of the archive, or preserves it if the archive failed and failed workspaces are kept.
+func (r *runner) cleanArchiveDir(a *archive, status scriptrunner.Status, L *zap.Logger) {
+	if r.keepFailed && status == scriptrunner.StatusFailed {
+		dir, err := r.ws.PreserveArchiveDir(a.File)
+		if err == nil {
+			L.Info("preserved failed archive workspace", zap.String("directory", dir))
+			return
+		}
+		L.Error("error preserving failed archive workspace", zap.Error(err))
+	}
+	if err := r.ws.RemoveArchiveDir(a.File); err != nil {
+		L.Error("error cleaning archive workspace", zap.Error(err))
+	}
+}
+
+// runScript executes a single script and returns its result.
+func (r *runner) runScript(pwsh *powershell.PowerShell, a *archive, archiveDir, script string, L *zap.Logger) *scriptrunner.ScriptResult {
+	fullPath := filepath.Join(archiveDir, filepath.FromSlash(script))
+	L.Info("executing script", zap.String("script", fullPath))
+	result := &scriptrunner.ScriptResult{
+		Script:  script,
+		Status:  scriptrunner.StatusSucceeded,
+		Started: time.Now().UTC(),
+	}
+	stdOut, stdErr, err := pwsh.Execute(fullPath)
+	stdOut, stdErr = r.scrubber.Scrub(stdOut), r.scrubber.Scrub(stdErr)
+	result.Duration = time.Since(result.Started)
+	result.ExitCode = scriptrunner.ExitCode(err)
+	fields := []zap.Field{zap.String("script", script), zap.Int("exitCode", result.ExitCode), zap.Duration("duration", result.Duration)}
+	switch {
+	case err != nil:
+		var errMsg string
+		if stdErr != "" {
+			errMsg += stdErr + `; `
+		}
+		errMsg += r.scrubber.Scrub(err.Error())
+		result.Status = scriptrunner.StatusFailed
+		result.Error = errMsg
+		L.Error("error running script", append(fields, zap.String(`error`, errMsg))...)
+	case stdErr != "":
+		r.printOutput(a.File, script, stdOut)
+		result.Status = scriptrunner.StatusFailed
+		result.Error = stdErr
+		L.Error("error running script", append(fields, zap.String(`error`, stdErr))...)
+	default:
+		r.printOutput(a.File, script, stdOut)
+		L.Info("script succeeded", fields...)
+	}
+	var outTruncated, errTruncated bool
+	result.Output, outTruncated = scriptrunner.Truncate(stdOut, scriptrunner.MaxReportOutput)
+	result.Error, errTruncated = scriptrunner.Truncate(result.Error, scriptrunner.MaxReportOutput)
+	result.Truncated = outTruncated || errTruncated
+	if r.outputDir != "" {
+		file, err := r.saveOutput(a, script, stdOut, stdErr)
+		if err != nil {
+			L.Error("error saving script output", zap.String("script", script), zap.Error(err))
+		}
+		result.OutputFile = file
+	}
+	return result
+}
+
+// saveOutput writes the full output of a script to the output directory and returns the path of the file.
+func (r *runner) saveOutput(a *archive, script, stdOut, stdErr string) (string, error) {
+	file := filepath.Join(r.outputDir, r.ws.RunID, a.File, filepath.FromSlash(script)+`.log`)
+	if err := scriptrunner.CreateDir(filepath.Dir(file)); err != nil {
+		return "", fmt.Errorf("error creating output directory: %w", err)
+	}
+	out := stdOut
+	if stdErr != "" {
+		out += "\n--- stderr ---\n" + stdErr
+	}
+	if err := ioutil.WriteFile(file, []byte(out), 0640); err != nil {
+		return "", fmt.Errorf("error writing output file: %w", err)
+	}
+	return file, nil
+}
+
+// printOutput writes script output prefixed with its archive and script so that
+// output from archives running in parallel remains attributable.
+func (r *runner) printOutput(archive, script, out string) {
+	out = strings.TrimRight(out, "\r\n")
+	if out == "" {
+		return
+	}
+	prefix := `[` + archive + `/` + script + `] `
+	r.outLock.Lock()
+	defer r.outLock.Unlock()
+	for _, line := range strings.Split(out, "\n") {
+		fmt.Fprintln(r.out, prefix+strings.TrimRight(line, "\r"))
+	}
+}
+
+// dependencyState returns whether all dependencies of the given node have finished.
+// If any dependency is missing or did not succeed, the reason is returned.
+func dependencyState(g *scriptrunner.Graph, node string, statuses map[string]scriptrunner.Status) (bool, string) {
+	if missing := g.Missing(node); len(missing) > 0 {
+		return true, "missing dependency " + strings.Join(missing, `, `)
+	}
+	ready := true
+	for _, d := range g.Dependencies(node) {
+		status, ok := statuses[d]
+		switch {
+		case !ok:
+			ready = false
+		case status != scriptrunner.StatusSucceeded && status != scriptrunner.StatusSatisfied:
+			return true, "dependency " + d + " " + string(status)
+		}
+	}
+	return ready, ""
+}
+
+// loadArchives reads the manifest of each archive file within the scripts directory.
+func loadArchives(scripts string, files []string, L *zap.Logger) []*archive {
+	archives := make([]*archive, 0, len(files))
+	for _, f := range files {
+		path := filepath.Join(scripts, f)
+		m, err := scriptrunner.ReadManifest(path)
+		if err != nil {
+			L.Error("error reading archive manifest, skipping", zap.String("archive", f), zap.Error(err))
+			continue
+		}
+		hash, err := scriptrunner.FileHash(path)
+		if err != nil {
+			L.Error("error hashing archive, skipping", zap.String("archive", f), zap.Error(err))
+			continue
+		}
+		archives = append(archives, &archive{
+			File:     f,
+			Path:     path,
+			Hash:     hash,
+			Manifest: m,
+		})
+	}
+	return archives
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jbvmio/scriptrunner"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+)
+
+const (
+	secretsPath = `/secrets`
+	secretsDir  = `.secrets`
+)
+
+// loadSecrets retrieves the secrets HomeBase holds for the client and those within the local secrets file,
+// which take precedence, and decrypts them using the client key. The values are registered with the scrubber.
+// If HomeBase cannot be reached, the error is logged and only the local secrets are used.
+func (a *agent) loadSecrets() (map[string]string, error) {
+	S := make(scriptrunner.Secrets)
+	if a.client != nil && a.apiURL != "" {
+		remote := make(scriptrunner.Secrets)
+		if err := getJSON(a.client, strings.TrimRight(a.apiURL, `/`)+secretsPath, &remote); err != nil {
+			a.logger.Error("error retrieving secrets from HomeBase", zap.Error(err))
+			remote = nil
+		}
+		for name, e := range remote {
+			S[name] = e
+		}
+	}
+	if a.secretsFile != "" {
+		local, err := scriptrunner.LoadSecrets(a.secretsFile)
+		if err != nil {
+			return nil, err
+		}
+		for name, e := range local {
+			S[name] = e
+		}
+	}
+	if len(S) == 0 {
+		return nil, nil
+	}
+	key, err := scriptrunner.LoadPrivateKey(a.secretKey)
+	if err != nil {
+		return nil, err
+	}
+	values, err := S.Decrypt(key)
+	if err != nil {
+		return nil, err
+	}
+	for name, v := range values {
+		if len(v) < scriptrunner.MinScrubLength {
+			a.logger.Warn("secret too short to be scrubbed from logs and reports", zap.String("secret", name), zap.Int("minLength", scriptrunner.MinScrubLength))
+		}
+		a.scrubber.Add(v)
+	}
+	a.logger.Info("secrets loaded", zap.Strings("secrets", S.Names()))
+	return values, nil
+}
+
+// secretEnv returns the environment variables exposing the secrets the archive declares. Secrets exposed as files
+// are written to a private directory within the run directory, which is returned so it can be removed afterwards.
+func (r *runner) secretEnv(a *archive) ([]string, string, error) {
+	if len(a.Manifest.Secrets) == 0 {
+		return nil, "", nil
+	}
+	var env []string
+	var dir string
+	for _, s := range a.Manifest.Secrets {
+		v, ok := r.secrets[s.Name]
+		if !ok {
+			return nil, dir, fmt.Errorf("missing secret %q", s.Name)
+		}
+		if !s.File {
+			env = append(env, s.EnvName()+`=`+v)
+			continue
+		}
+		if dir == "" {
+			dir = filepath.Join(r.ws.RunDir, secretsDir, scriptrunner.ArchiveName(a.File))
+			if err := os.MkdirAll(dir, 0700); err != nil {
+				return nil, dir, fmt.Errorf("error creating secrets directory: %w", err)
+			}
+		}
+		file := filepath.Join(dir, s.EnvName())
+		if err := ioutil.WriteFile(file, []byte(v), 0600); err != nil {
+			return nil, dir, fmt.Errorf("error writing secret %q: %w", s.Name, err)
+		}
+		env = append(env, s.EnvName()+`=`+file)
+	}
+	return env, dir, nil
+}
+
+// runSecrets manages a local secrets file.
+func runSecrets(args []string) {
+	var cert, file string
+	pf := pflag.NewFlagSet("scriptrunner secrets", pflag.ExitOnError)
+	pf.StringVar(&cert, `cert`, "", "Filepath to the Certificate or Public Key of the Client to Encrypt the Secret to.")
+	pf.StringVarP(&file, `file`, `f`, "", "Filepath of the Secrets File to Add the Secret to, Created if Missing.")
+	pf.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: scriptrunner secrets encrypt --cert <file> --file <secrets.json> <name> < value\n")
+		pf.PrintDefaults()
+	}
+	pf.Parse(args)
+	if pf.NArg() != 2 || pf.Arg(0) != `encrypt` || cert == "" || file == "" {
+		pf.Usage()
+		os.Exit(exitConfigError)
+	}
+	name := pf.Arg(1)
+	pub, err := scriptrunner.LoadPublicKey(cert)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading public key: %v\n", err)
+		os.Exit(exitConfigError)
+	}
+	value, err := ioutil.ReadAll(bufio.NewReader(os.Stdin))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading secret value: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	value = []byte(strings.TrimRight(string(value), "\r\n"))
+	S, err := scriptrunner.LoadSecrets(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFailure)
+	}
+	S[name], err = scriptrunner.EncryptSecret(pub, name, value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error encrypting secret: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	if err := S.Save(file); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFailure)
+	}
+	fmt.Fprintf(os.Stderr, "secret %s written to %s\n", name, file)
+}
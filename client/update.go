@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/jbvmio/scriptrunner"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+)
+
+const (
+	latestReleasePath   = `/releases/latest`
+	downloadReleasePath = `/releases/download`
+	updateStateFile     = `update.json`
+
+	defaultHealthTimeout = 30 * time.Second
+)
+
+// updateState records the versions installed by updates so that versions failing their health check are not retried.
+type updateState struct {
+	Version  string    `json:"version"`
+	Previous string    `json:"previous"`
+	Updated  time.Time `json:"updated"`
+	Failed   []string  `json:"failed,omitempty"`
+}
+
+// health is printed by the health subcommand.
+type health struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	OS      string `json:"os"`
+	Arch    string `json:"arch"`
+}
+
+// selfUpdate installs the latest release HomeBase offers for the platform of the client if it is newer than
+// the running version, and returns the path of the updated executable or an empty string if nothing was installed.
+// Builds without a version set at build time never update themselves.
+func (a *agent) selfUpdate() string {
+	if a.updateKey == nil || a.client == nil || a.apiURL == "" {
+		return ""
+	}
+	if buildTime == "" {
+		a.logger.Warn("running version is unknown, skipping self-update")
+		return ""
+	}
+	exe, release, err := a.update()
+	switch {
+	case err != nil:
+		a.logger.Error("error updating client", zap.Error(err))
+	case release != nil:
+		a.logger.Info("client updated", zap.String("from", buildTime), zap.String("to", release.Version), zap.String("executable", exe))
+		return exe
+	}
+	return ""
+}
+
+// update downloads the latest release and verifies its signature and checksum before moving it into place of the
+// running executable, keeping the previous binary alongside as .old. The new binary must pass a health check,
+// otherwise the previous binary is restored and the version is recorded as failed.
+func (a *agent) update() (string, *scriptrunner.Release, error) {
+	api := strings.TrimRight(a.apiURL, `/`)
+	query := `?os=` + url.QueryEscape(runtime.GOOS) + `&arch=` + url.QueryEscape(runtime.GOARCH)
+	var release scriptrunner.Release
+	if err := getJSON(a.client, api+latestReleasePath+query, &release); err != nil {
+		return "", nil, fmt.Errorf("error retrieving latest release: %w", err)
+	}
+	state := a.loadUpdateState()
+	newer := scriptrunner.CompareVersions(release.Version, buildTime)
+	switch {
+	case release.Version == "" || newer == 0:
+		return "", nil, nil
+	case newer < 0:
+		return "", nil, fmt.Errorf("refusing release %s older than running version %s", release.Version, buildTime)
+	case contains(state.Failed, release.Version):
+		a.logger.Debug("skipping release that failed its health check", zap.String("version", release.Version))
+		return "", nil, nil
+	case release.OS != runtime.GOOS || release.Arch != runtime.GOARCH:
+		return "", nil, fmt.Errorf("release %s is for %s/%s", release.Version, release.OS, release.Arch)
+	}
+	if err := release.Verify(a.updateKey); err != nil {
+		return "", nil, err
+	}
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("error locating executable: %w", err)
+	}
+	newExe, oldExe := exe+`.new`, exe+`.old`
+	a.logger.Info("downloading release", zap.String("version", release.Version))
+	if err := download(a.client, api+downloadReleasePath+query, newExe, release.SHA256); err != nil {
+		return "", nil, fmt.Errorf("error downloading release: %w", err)
+	}
+	if err := os.Chmod(newExe, 0755); err != nil {
+		os.Remove(newExe)
+		return "", nil, fmt.Errorf("error setting permissions for release: %w", err)
+	}
+	os.Remove(oldExe)
+	if err := os.Rename(exe, oldExe); err != nil {
+		os.Remove(newExe)
+		return "", nil, fmt.Errorf("error moving current executable: %w", err)
+	}
+	if err := os.Rename(newExe, exe); err != nil {
+		os.Rename(oldExe, exe)
+		return "", nil, fmt.Errorf("error installing release: %w", err)
+	}
+	if err := a.healthCheck(exe, release.Version); err != nil {
+		state.Failed = append(state.Failed, release.Version)
+		a.saveUpdateState(state)
+		os.Remove(exe)
+		if rerr := os.Rename(oldExe, exe); rerr != nil {
+			return "", nil, fmt.Errorf("release %s failed health check: %v: error rolling back: %w", release.Version, err, rerr)
+		}
+		return "", nil, fmt.Errorf("release %s failed health check, rolled back: %w", release.Version, err)
+	}
+	state.Version, state.Previous, state.Updated = release.Version, buildTime, time.Now().UTC()
+	a.saveUpdateState(state)
+	return exe, &release, nil
+}
+
+// healthCheck runs the health subcommand of the given executable with the settings of the running client
+// and returns an error if it fails, does not finish within the health timeout or reports another version.
+func (a *agent) healthCheck(exe, version string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), a.healthTimeout)
+	defer cancel()
+	args := []string{`health`, `--api`, a.apiURL, `--cacert`, a.tls.CACert, `--cert`, a.tls.Cert, `--key`, a.tls.Key}
+	if a.configPath != "" {
+		args = append(args, `--config`, a.configPath)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, exe, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("timed out after %s", a.healthTimeout)
+		}
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	var H health
+	if err := json.Unmarshal(stdout.Bytes(), &H); err != nil {
+		return fmt.Errorf("error decoding health: %w", err)
+	}
+	if H.Version != version {
+		return fmt.Errorf("reported version %q, expected %q", H.Version, version)
+	}
+	return nil
+}
+
+func (a *agent) loadUpdateState() *updateState {
+	var S updateState
+	b, err := ioutil.ReadFile(a.updateState)
+	if err == nil {
+		err = json.Unmarshal(b, &S)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		a.logger.Warn("error reading update state", zap.String("file", a.updateState), zap.Error(err))
+	}
+	return &S
+}
+
+func (a *agent) saveUpdateState(S *updateState) {
+	b, err := json.MarshalIndent(S, "", "  ")
+	if err == nil {
+		err = scriptrunner.WriteFileAtomic(a.updateState, b, 0640)
+	}
+	if err != nil {
+		a.logger.Error("error writing update state", zap.String("file", a.updateState), zap.Error(err))
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// runHealth checks that the client can load its config and, if an API is configured, reach HomeBase,
+// then prints its version. It is run against a newly installed release before the client restarts.
+func runHealth(args []string) {
+	var path, api, caCert, cert, key string
+	pf := pflag.NewFlagSet("scriptrunner health", pflag.ExitOnError)
+	pf.StringVarP(&path, `config`, `c`, "", "Path of Config File to Use. Defaults to config.yaml within the Executable Directory.")
+	pf.StringVar(&api, `api`, "", "Alternate HomeBase API URL to Check, Overwrites Config HomeBaseAPI Value.")
+	pf.StringVar(&caCert, `cacert`, "", "Filepath to HomeBase Signing Certificate CA.")
+	pf.StringVar(&cert, `cert`, "", "Filepath to Client Certificate.")
+	pf.StringVar(&key, `key`, "", "Filepath to Client Key.")
+	pf.Parse(args)
+	fail := func(code int, format string, a ...interface{}) {
+		fmt.Fprintf(os.Stderr, format+"\n", a...)
+		os.Exit(code)
+	}
+	cwd, err := scriptrunner.GetCWD()
+	if err != nil {
+		fail(exitConfigError, "error retrieving cwd: %v", err)
+	}
+	if path == "" {
+		path = filepath.Join(cwd, configFile)
+	}
+	C, _, err := loadConfig(path, pf.Changed(`config`))
+	if err == nil {
+		err = checkConfigFiles(cwd, C)
+	}
+	if err != nil {
+		fail(exitConfigError, "invalid config %s: %v", path, err)
+	}
+	applyDefaults(C)
+	if api == "" {
+		api = C.HomeBaseAPI
+	}
+	if api != "" {
+		client, err := newHTTPClient(resolveTLS(cwd, C.TLS, caCert, cert, key), C.HTTPTimeout)
+		if err != nil {
+			fail(exitConfigError, "error configuring HomeBase client: %v", err)
+		}
+		resp, err := client.Get(strings.TrimRight(api, `/`) + `/`)
+		if err != nil {
+			fail(exitFailure, "error reaching HomeBase: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			fail(exitFailure, "unexpected HomeBase response status: %s", resp.Status)
+		}
+	}
+	json.NewEncoder(os.Stdout).Encode(health{
+		Version: buildTime,
+		Commit:  commitHash,
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+	})
+}
+
+// runRelease publishes a client binary to a HomeBase releases directory.
+func runRelease(args []string) {
+	var dir, version, goos, goarch, signKey string
+	pf := pflag.NewFlagSet("scriptrunner release", pflag.ExitOnError)
+	pf.StringVarP(&dir, `dir`, `d`, `releases`, "Releases Directory Served by HomeBase.")
+	pf.StringVar(&version, `version`, "", "Version of the Binary, as Set at Build Time.")
+	pf.StringVar(&goos, `os`, runtime.GOOS, "Operating System of the Binary.")
+	pf.StringVar(&goarch, `arch`, runtime.GOARCH, "Architecture of the Binary.")
+	pf.StringVar(&signKey, `sign-key`, "", "Sign the Release using the Given PEM ed25519 Private Key.")
+	pf.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: scriptrunner release --version <version> --sign-key <key> [flags] <binary>\n")
+		pf.PrintDefaults()
+	}
+	pf.Parse(args)
+	if pf.NArg() != 1 || version == "" || signKey == "" {
+		pf.Usage()
+		os.Exit(exitConfigError)
+	}
+	key, err := scriptrunner.LoadSigningKey(signKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading signing key: %v\n", err)
+		os.Exit(exitConfigError)
+	}
+	R, err := scriptrunner.PublishRelease(pf.Arg(0), dir, version, goos, goarch, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error publishing release: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	fmt.Fprintf(os.Stderr, "Published %s %s/%s to %s, sha256: %s\n", R.Version, R.OS, R.Arch, filepath.Join(dir, R.OS, R.Arch), R.SHA256)
+}
@@ -1,26 +1,154 @@
 package scriptrunner
 
 import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Config defines configuration options.
 type Config struct {
-	HomeBase     string `yaml:"homeBase"`
-	ScriptsDir   string `yaml:"scriptsDir"`
-	WorkspaceDir string `yaml:"workspaceDir"`
-	CertsDir     string `yaml:"certDir"`
+	HomeBase     string        `yaml:"homeBase"`
+	HomeBaseAPI  string        `yaml:"homeBaseAPI"`
+	RemoteDir    string        `yaml:"remoteDir"`
+	ScriptsDir   string        `yaml:"scriptsDir"`
+	WorkspaceDir string        `yaml:"workspaceDir"`
+	CertsDir     string        `yaml:"certDir"`
+	Concurrency  int           `yaml:"concurrency"`
+	KeepFailed   bool          `yaml:"keepFailed"`
+	Retention    Retention     `yaml:"retention"`
+	StateFile    string        `yaml:"stateFile"`
+	LockFile     string        `yaml:"lockFile"`
+	VerifyKey    string        `yaml:"verifyKey"`
+	SecretsFile  string        `yaml:"secretsFile"`
+	EnrollToken  string        `yaml:"enrollToken"`
+	Interval     time.Duration `yaml:"interval"`
+	Splay        time.Duration `yaml:"splay"`
+	ScheduleFile string        `yaml:"scheduleFile"`
+	Heartbeat    time.Duration `yaml:"heartbeat"`
+	ReportFormat ReportFormat  `yaml:"reportFormat"`
+	ReportFile   string        `yaml:"reportFile"`
+	OutputDir    string        `yaml:"outputDir"`
+	LogLevel     string        `yaml:"logLevel"`
+	LogFile      string        `yaml:"logFile"`
+
+	ScriptTimeout time.Duration `yaml:"scriptTimeout"`
+	HTTPTimeout   time.Duration `yaml:"httpTimeout"`
+	FailurePolicy FailurePolicy `yaml:"failurePolicy"`
+	Executors     Executors     `yaml:"executors"`
+	TLS           TLSConfig     `yaml:"tls"`
+	ReportQueue   QueueConfig   `yaml:"reportQueue"`
+	Update        UpdateConfig  `yaml:"update"`
+
+	Labels map[string]string `yaml:"labels"`
+	Vars   map[string]string `yaml:"vars"`
+
+	MaintenanceWindows []MaintenanceWindow `yaml:"maintenanceWindows"`
+}
+
+// Executors defines the paths of the executables used to run scripts.
+// Empty paths are looked up in the PATH.
+type Executors struct {
+	PowerShell string `yaml:"powershell"`
+}
+
+// TLSConfig defines the TLS settings used to connect to HomeBase.
+// Empty certificate paths default to files within the certs directory.
+// RenewAfter is the share of the lifetime of the client certificate after which it is renewed, such as 0.67.
+type TLSConfig struct {
+	CACert     string  `yaml:"caCert"`
+	Cert       string  `yaml:"cert"`
+	Key        string  `yaml:"key"`
+	ServerName string  `yaml:"serverName"`
+	MinVersion string  `yaml:"minVersion"`
+	RenewAfter float64 `yaml:"renewAfter"`
+}
+
+// UpdateConfig defines how the client updates itself to the latest release offered by HomeBase.
+// Releases must be signed by the ed25519 private key matching VerifyKey.
+type UpdateConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	VerifyKey     string        `yaml:"verifyKey"`
+	HealthTimeout time.Duration `yaml:"healthTimeout"`
+}
+
+// Version returns the minimum TLS version, defaulting to TLS 1.2.
+func (t *TLSConfig) Version() (uint16, error) {
+	switch t.MinVersion {
+	case "", `1.2`:
+		return tls.VersionTLS12, nil
+	case `1.3`:
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("invalid tls minVersion %q, expected 1.2 or 1.3", t.MinVersion)
 }
 
 // GetConfig creates and returns a Config from the given filepath.
+// Unknown keys are rejected so that misspelled options are not silently ignored.
 func GetConfig(path string) (*Config, error) {
 	var C Config
 	b, err := ioutil.ReadFile(path)
 	if err != nil {
 		return &C, err
 	}
-	err = yaml.Unmarshal(b, &C)
-	return &C, err
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&C); err != nil && err != io.EOF {
+		return &C, fmt.Errorf("error unmarshaling config: %w", err)
+	}
+	return &C, nil
+}
+
+// Validate returns an error if the Config contains invalid options.
+func (c *Config) Validate() error {
+	switch strings.ToLower(c.LogLevel) {
+	case "", `debug`, `info`, `warn`, `error`:
+	default:
+		return fmt.Errorf("invalid logLevel %q", c.LogLevel)
+	}
+	for name, d := range map[string]time.Duration{
+		`interval`:             c.Interval,
+		`splay`:                c.Splay,
+		`heartbeat`:            c.Heartbeat,
+		`scriptTimeout`:        c.ScriptTimeout,
+		`httpTimeout`:          c.HTTPTimeout,
+		`retention.maxAge`:     c.Retention.MaxAge,
+		`reportQueue.maxAge`:   c.ReportQueue.MaxAge,
+		`update.healthTimeout`: c.Update.HealthTimeout,
+	} {
+		if d < 0 {
+			return fmt.Errorf("invalid %s %s, must not be negative", name, d)
+		}
+	}
+	switch {
+	case c.Concurrency < 0:
+		return fmt.Errorf("invalid concurrency %d, must not be negative", c.Concurrency)
+	case c.Retention.MaxCount < 0 || c.Retention.MaxSizeMB < 0:
+		return fmt.Errorf("invalid retention, limits must not be negative")
+	case c.ReportQueue.MaxCount < 0 || c.ReportQueue.MaxSizeMB < 0:
+		return fmt.Errorf("invalid reportQueue, limits must not be negative")
+	case c.ReportFormat != "" && !c.ReportFormat.Valid():
+		return fmt.Errorf("invalid reportFormat %q", c.ReportFormat)
+	case !c.FailurePolicy.Valid():
+		return fmt.Errorf("invalid failurePolicy %q", c.FailurePolicy)
+	case c.TLS.RenewAfter < 0 || c.TLS.RenewAfter >= 1:
+		return fmt.Errorf("invalid tls renewAfter %v, must be between 0 and 1", c.TLS.RenewAfter)
+	case c.Update.Enabled && c.Update.VerifyKey == "":
+		return fmt.Errorf("update.verifyKey is required when updates are enabled")
+	}
+	if _, err := c.TLS.Version(); err != nil {
+		return err
+	}
+	for _, w := range c.MaintenanceWindows {
+		if err := w.Validate(); err != nil {
+			return fmt.Errorf("invalid maintenance window: %w", err)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,177 @@
+package scriptrunner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed standard 5 field cron expression: minute, hour, day of month, month and day of week.
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+	loc                           *time.Location
+}
+
+type cronField struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronMinute = cronField{min: 0, max: 59}
+	cronHour   = cronField{min: 0, max: 23}
+	cronDom    = cronField{min: 1, max: 31}
+	cronMonth  = cronField{min: 1, max: 12, names: map[string]int{
+		`jan`: 1, `feb`: 2, `mar`: 3, `apr`: 4, `may`: 5, `jun`: 6,
+		`jul`: 7, `aug`: 8, `sep`: 9, `oct`: 10, `nov`: 11, `dec`: 12,
+	}}
+	cronDow = cronField{min: 0, max: 7, names: map[string]int{
+		`sun`: 0, `mon`: 1, `tue`: 2, `wed`: 3, `thu`: 4, `fri`: 5, `sat`: 6,
+	}}
+	cronDescriptors = map[string]string{
+		`@yearly`:   `0 0 1 1 *`,
+		`@annually`: `0 0 1 1 *`,
+		`@monthly`:  `0 0 1 * *`,
+		`@weekly`:   `0 0 * * 0`,
+		`@daily`:    `0 0 * * *`,
+		`@midnight`: `0 0 * * *`,
+		`@hourly`:   `0 * * * *`,
+	}
+)
+
+// ParseCron parses the given cron expression, evaluated in the given location.
+// Fields support "*", lists, ranges, steps and month and weekday names, as do the descriptors such as "@daily".
+func ParseCron(expr string, loc *time.Location) (*CronSchedule, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	expr = strings.TrimSpace(expr)
+	if d, ok := cronDescriptors[strings.ToLower(expr)]; ok {
+		expr = d
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields, found %d", expr, len(fields))
+	}
+	C := CronSchedule{
+		domStar: fields[2] == `*` || fields[2] == `?`,
+		dowStar: fields[4] == `*` || fields[4] == `?`,
+		loc:     loc,
+	}
+	var err error
+	for i, f := range []struct {
+		bits  *uint64
+		field cronField
+	}{
+		{&C.minute, cronMinute},
+		{&C.hour, cronHour},
+		{&C.dom, cronDom},
+		{&C.month, cronMonth},
+		{&C.dow, cronDow},
+	} {
+		*f.bits, err = f.field.parse(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+		}
+	}
+	if C.dow&(1<<7) != 0 {
+		C.dow |= 1
+	}
+	return &C, nil
+}
+
+// Next returns the first scheduled time after t, or the zero time if none exists within five years.
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	t = t.In(c.loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, c.loc)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, c.loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, c.loc)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches follows cron semantics: when both day fields are restricted, either may match.
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.domStar && c.dowStar:
+		return true
+	case c.domStar:
+		return dow
+	case c.dowStar:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+func (f cronField) parse(s string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, `,`) {
+		rng, step := part, 1
+		if i := strings.Index(part, `/`); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rng, step = part[:i], n
+		}
+		lo, hi := f.min, f.max
+		switch {
+		case rng == `*` || rng == `?`:
+		case strings.Contains(rng, `-`):
+			i := strings.Index(rng, `-`)
+			var err error
+			if lo, err = f.value(rng[:i]); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(rng[i+1:]); err != nil {
+				return 0, err
+			}
+		default:
+			v, err := f.value(rng)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			if step == 1 {
+				hi = v
+			}
+		}
+		if lo > hi {
+			return 0, fmt.Errorf("invalid range %q", rng)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %d out of range [%d-%d]", v, f.min, f.max)
+	}
+	return v, nil
+}
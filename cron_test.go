@@ -0,0 +1,74 @@
+package scriptrunner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"-1 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"30-10 * * * *",
+		"* * * foo *",
+		"@every 5m",
+	} {
+		if _, err := ParseCron(expr, time.UTC); err == nil {
+			t.Errorf("ParseCron(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse(`2006-01-02 15:04:05`, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		expr, from, want string
+	}{
+		{"0 0 1 1 *", "2026-03-10 12:00:00", "2027-01-01 00:00:00"},
+		{"59 23 31 12 *", "2026-01-01 00:00:00", "2026-12-31 23:59:00"},
+		{"0 0 29 2 *", "2026-03-01 00:00:00", "2028-02-29 00:00:00"},
+		{"0 0 31 * *", "2026-04-01 00:00:00", "2026-05-31 00:00:00"},
+		{"*/15 * * * *", "2026-10-18 10:14:30", "2026-10-18 10:15:00"},
+		{"5-10/2 * * * *", "2026-10-18 10:07:00", "2026-10-18 10:09:00"},
+		{"0,59 * * * *", "2026-10-18 10:00:00", "2026-10-18 10:59:00"},
+		{"@hourly", "2026-10-18 10:00:00", "2026-10-18 11:00:00"},
+		// 2026-10-18 is a Sunday, both 0 and 7 select it.
+		{"0 12 * * 0", "2026-10-17 13:00:00", "2026-10-18 12:00:00"},
+		{"0 12 * * 7", "2026-10-17 13:00:00", "2026-10-18 12:00:00"},
+		{"0 12 * * 5-7", "2026-10-17 13:00:00", "2026-10-18 12:00:00"},
+		{"0 12 * * mon-fri", "2026-10-16 13:00:00", "2026-10-19 12:00:00"},
+		{"0 0 * jan-mar/2 *", "2026-01-31 12:00:00", "2026-03-01 00:00:00"},
+		// With both day fields restricted either one matches.
+		{"0 0 1 * mon", "2026-10-18 00:00:00", "2026-10-19 00:00:00"},
+		{"0 0 1 * mon", "2026-10-26 12:00:00", "2026-11-01 00:00:00"},
+		// February never has 30 days.
+		{"0 0 30 2 *", "2026-01-01 00:00:00", "0001-01-01 00:00:00"},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr, time.UTC)
+		if err != nil {
+			t.Errorf("ParseCron(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := c.Next(at(tt.from)); !got.Equal(at(tt.want)) {
+			t.Errorf("ParseCron(%q).Next(%s) = %s, want %s", tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package scriptrunner
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultInclude contains the patterns used to discover scripts when an archive does not declare any.
+var DefaultInclude = []string{`*.ps1`}
+
+// DiscoverScripts returns the slash separated paths, relative to dir, of the scripts matching any of the
+// include patterns and none of the exclude patterns. Patterns containing a "/" are matched against the
+// relative path, all others against the file name. Directories matching an exclude pattern are not searched.
+// Subdirectories are only searched when recursive is true. Scripts are ordered by their relative path.
+func DiscoverScripts(dir string, include, exclude []string, recursive bool) ([]string, error) {
+	if len(include) == 0 {
+		include = DefaultInclude
+	}
+	for _, p := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+	var scripts []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		switch {
+		case rel == `.`:
+			return nil
+		case d.IsDir():
+			if !recursive || matchAny(exclude, rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		case rel == ManifestFile, rel == SignatureFile:
+			return nil
+		case matchAny(include, rel) && !matchAny(exclude, rel):
+			scripts = append(scripts, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error discovering scripts: %w", err)
+	}
+	sort.Strings(scripts)
+	return scripts, nil
+}
+
+func matchAny(patterns []string, rel string) bool {
+	name := path.Base(rel)
+	for _, p := range patterns {
+		target := name
+		if strings.Contains(p, `/`) {
+			target = rel
+		}
+		if ok, _ := path.Match(p, target); ok {
+			return true
+		}
+	}
+	return false
+}
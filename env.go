@@ -0,0 +1,93 @@
+package scriptrunner
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding Config values.
+const EnvPrefix = `SCRIPTRUNNER_`
+
+// ApplyEnv overrides Config values with the environment variables named by EnvPrefix followed by the upper cased
+// yaml key, with nested keys joined by underscores, such as SCRIPTRUNNER_HOMEBASE or SCRIPTRUNNER_TLS_CACERT.
+// Labels are given as comma separated key=value pairs. Lists such as maintenance windows cannot be overridden.
+func (c *Config) ApplyEnv() error {
+	return applyEnv(reflect.ValueOf(c).Elem(), EnvPrefix)
+}
+
+func applyEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get(`yaml`), `,`)[0]
+		if key == "" || key == `-` {
+			continue
+		}
+		name := prefix + strings.ToUpper(key)
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, name+`_`); err != nil {
+				return err
+			}
+			continue
+		}
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setValue(field, s); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setValue(v reflect.Value, s string) error {
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case v.Kind() >= reflect.Int && v.Kind() <= reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String && v.Type().Elem().Kind() == reflect.String:
+		m := reflect.MakeMap(v.Type())
+		for _, pair := range strings.Split(s, `,`) {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			kv := strings.SplitN(pair, `=`, 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+				return fmt.Errorf("invalid pair %q, expected key=value", pair)
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(kv[0])).Convert(v.Type().Key()), reflect.ValueOf(strings.TrimSpace(kv[1])).Convert(v.Type().Elem()))
+		}
+		v.Set(m)
+	default:
+		return fmt.Errorf("%s values cannot be set from the environment", v.Type())
+	}
+	return nil
+}
@@ -1,5 +1,23 @@
 package scriptrunner
 
+import (
+	"errors"
+	"os/exec"
+)
+
 type Executor interface {
 	Execute(args ...string) (stdOut string, stdErr string, err error)
 }
+
+// ExitCode returns the exit code of a process from the error returned by an Executor.
+// A nil error returns 0 and errors not caused by the process exiting return -1.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
@@ -0,0 +1,67 @@
+package scriptrunner
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+)
+
+// HostFacts describes the host a client runs on.
+type HostFacts struct {
+	Hostname string            `json:"hostname"`
+	OS       string            `json:"os"`
+	Arch     string            `json:"arch"`
+	IPs      []string          `json:"ips,omitempty"`
+	Version  string            `json:"version,omitempty"`
+	Commit   string            `json:"commit,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// GetHostFacts gathers the HostFacts of the current host, including the given client version, commit and labels.
+// Loopback and link-local addresses are not included.
+func GetHostFacts(version, commit string, labels map[string]string) (*HostFacts, error) {
+	F := HostFacts{
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+		Version: version,
+		Commit:  commit,
+		Labels:  labels,
+	}
+	var err error
+	F.Hostname, err = os.Hostname()
+	if err != nil {
+		return &F, fmt.Errorf("error retrieving hostname: %w", err)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return &F, fmt.Errorf("error retrieving interface addresses: %w", err)
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+			continue
+		}
+		F.IPs = append(F.IPs, ip.String())
+	}
+	sort.Strings(F.IPs)
+	return &F, nil
+}
+
+// SelectorLabels returns the labels of the host matched by archive selectors.
+// The hostname, os and arch facts are included and take precedence over configured labels of the same name.
+func (f *HostFacts) SelectorLabels() map[string]string {
+	labels := make(map[string]string, len(f.Labels)+3)
+	for k, v := range f.Labels {
+		labels[k] = v
+	}
+	labels[`hostname`] = f.Hostname
+	labels[`os`] = f.OS
+	labels[`arch`] = f.Arch
+	return labels
+}
@@ -0,0 +1,117 @@
+package scriptrunner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Graph is a directed graph of named nodes and the nodes they depend on.
+type Graph struct {
+	nodes      map[string]bool
+	deps       map[string][]string
+	dependents map[string][]string
+}
+
+// CycleError is returned when nodes of a Graph cannot be ordered due to a dependency cycle.
+// Nodes contains every node that could not be ordered, including those depending on a cycle.
+type CycleError struct {
+	Nodes []string
+}
+
+func (e *CycleError) Error() string {
+	return fmt.Sprintf("dependency cycle detected between: %s", strings.Join(e.Nodes, `, `))
+}
+
+// NewGraph returns a new, empty Graph.
+func NewGraph() *Graph {
+	return &Graph{
+		nodes:      make(map[string]bool),
+		deps:       make(map[string][]string),
+		dependents: make(map[string][]string),
+	}
+}
+
+// AddNode adds the named node to the Graph.
+func (g *Graph) AddNode(name string) {
+	g.nodes[name] = true
+}
+
+// HasNode returns true if the named node exists in the Graph.
+func (g *Graph) HasNode(name string) bool {
+	return g.nodes[name]
+}
+
+// AddDependency records that node depends on dependsOn.
+func (g *Graph) AddDependency(node, dependsOn string) {
+	for _, d := range g.deps[node] {
+		if d == dependsOn {
+			return
+		}
+	}
+	g.deps[node] = append(g.deps[node], dependsOn)
+	g.dependents[dependsOn] = append(g.dependents[dependsOn], node)
+}
+
+// Dependencies returns the nodes the given node depends on.
+func (g *Graph) Dependencies(node string) []string {
+	return g.deps[node]
+}
+
+// Missing returns the dependencies of the given node which do not exist in the Graph.
+func (g *Graph) Missing(node string) []string {
+	var missing []string
+	for _, d := range g.deps[node] {
+		if !g.nodes[d] {
+			missing = append(missing, d)
+		}
+	}
+	return missing
+}
+
+// Sort returns the nodes in topological order, with independent nodes ordered by name.
+// Dependencies on missing nodes are ignored. If a cycle exists, the nodes that could be ordered
+// are returned along with a *CycleError.
+func (g *Graph) Sort() ([]string, error) {
+	inDegree := make(map[string]int, len(g.nodes))
+	for n := range g.nodes {
+		for _, d := range g.deps[n] {
+			if g.nodes[d] {
+				inDegree[n]++
+			}
+		}
+	}
+	var ready []string
+	for n := range g.nodes {
+		if inDegree[n] == 0 {
+			ready = append(ready, n)
+		}
+	}
+	order := make([]string, 0, len(g.nodes))
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		n := ready[0]
+		ready = ready[1:]
+		order = append(order, n)
+		for _, d := range g.dependents[n] {
+			if !g.nodes[d] {
+				continue
+			}
+			inDegree[d]--
+			if inDegree[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+	if len(order) < len(g.nodes) {
+		var cycle []string
+		for n := range g.nodes {
+			if inDegree[n] > 0 {
+				cycle = append(cycle, n)
+			}
+		}
+		sort.Strings(cycle)
+		return order, &CycleError{Nodes: cycle}
+	}
+	return order, nil
+}
@@ -0,0 +1,51 @@
+package scriptrunner
+
+import "time"
+
+// RemoteFile describes a file served by the HomeBase file server.
+type RemoteFile struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	SHA256  string    `json:"sha256"`
+}
+
+// ClientState is the current activity of a client.
+type ClientState string
+
+// Available ClientStates.
+const (
+	ClientIdle    ClientState = `idle`
+	ClientRunning ClientState = `running`
+	ClientStopped ClientState = `stopped`
+)
+
+// CheckIn is sent by a client to HomeBase when registering and with each heartbeat.
+type CheckIn struct {
+	HostFacts
+	State        ClientState `json:"state"`
+	LastRunID    string      `json:"lastRunID,omitempty"`
+	CertNotAfter time.Time   `json:"certNotAfter"`
+}
+
+// ClientRecord is what HomeBase knows about a registered client.
+type ClientRecord struct {
+	CheckIn
+	Client     string    `json:"client,omitempty"`
+	Registered time.Time `json:"registered"`
+	LastSeen   time.Time `json:"lastSeen"`
+}
+
+// EnrollRequest is sent by a client without a certificate to obtain one using a one-time bootstrap token,
+// or by an authenticated client renewing its certificate, which requires no token.
+// CSR is a PEM encoded certificate signing request whose common name identifies the client.
+type EnrollRequest struct {
+	Token string `json:"token"`
+	CSR   string `json:"csr"`
+}
+
+// EnrollResponse contains the PEM encoded client certificate issued by HomeBase.
+type EnrollResponse struct {
+	Certificate string    `json:"certificate"`
+	NotAfter    time.Time `json:"notAfter"`
+}
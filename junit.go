@@ -0,0 +1,162 @@
+package scriptrunner
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// junitSuites is the root element of a JUnit XML report.
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Errors     int             `xml:"errors,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr,omitempty"`
+	Hostname   string          `xml:"hostname,attr,omitempty"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Cases      []junitCase     `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the RunReport to w as JUnit XML, with a test suite per archive and a test case per script.
+// Archives that did not run any scripts are reported as a single test case named after the archive.
+func (r *RunReport) WriteJUnit(w io.Writer) error {
+	S := junitSuites{
+		Name: `scriptrunner ` + r.RunID,
+		Time: junitTime(r.Finished.Sub(r.Started)),
+	}
+	for _, a := range r.Archives {
+		suite := junitSuite{
+			Name:     a.Archive,
+			Time:     junitTime(a.Duration),
+			Hostname: r.Host,
+			Properties: []junitProperty{
+				{Name: `name`, Value: a.Name},
+				{Name: `status`, Value: string(a.Status)},
+			},
+		}
+		if !a.Started.IsZero() {
+			suite.Timestamp = a.Started.Format(time.RFC3339)
+		}
+		if a.Reason != "" {
+			suite.Properties = append(suite.Properties, junitProperty{Name: `reason`, Value: a.Reason})
+		}
+		for _, s := range a.Scripts {
+			suite.Cases = append(suite.Cases, scriptCase(a, s))
+		}
+		if len(a.Scripts) == 0 {
+			suite.Cases = append(suite.Cases, archiveCase(a))
+		}
+		for _, c := range suite.Cases {
+			suite.Tests++
+			switch {
+			case c.Failure != nil:
+				suite.Failures++
+			case c.Error != nil:
+				suite.Errors++
+			case c.Skipped != nil:
+				suite.Skipped++
+			}
+		}
+		S.Tests += suite.Tests
+		S.Failures += suite.Failures
+		S.Errors += suite.Errors
+		S.Skipped += suite.Skipped
+		S.Suites = append(S.Suites, suite)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(S); err != nil {
+		return fmt.Errorf("error encoding junit report: %w", err)
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func scriptCase(a *ArchiveResult, s *ScriptResult) junitCase {
+	C := junitCase{
+		Name:      s.Script,
+		ClassName: a.Name,
+		Time:      junitTime(s.Duration),
+		SystemOut: s.Output,
+	}
+	switch s.Status {
+	case StatusSucceeded:
+	case StatusFailed:
+		C.Failure = &junitMessage{Message: fmt.Sprintf("exit code %d", s.ExitCode), Text: s.Error}
+	default:
+		C.Skipped = &junitMessage{Message: string(s.Status) + reasonSuffix(s.Reason)}
+	}
+	if C.Failure == nil {
+		C.SystemErr = s.Error
+	}
+	if s.OutputFile != "" {
+		C.SystemOut += "\n[full output: " + s.OutputFile + "]"
+	}
+	return C
+}
+
+func archiveCase(a *ArchiveResult) junitCase {
+	C := junitCase{
+		Name:      a.Name,
+		ClassName: a.Name,
+		Time:      junitTime(a.Duration),
+	}
+	switch a.Status {
+	case StatusSucceeded:
+	case StatusFailed:
+		C.Error = &junitMessage{Message: a.Reason}
+	default:
+		C.Skipped = &junitMessage{Message: string(a.Status) + reasonSuffix(a.Reason)}
+	}
+	return C
+}
+
+func reasonSuffix(reason string) string {
+	if reason == "" {
+		return ""
+	}
+	return `: ` + reason
+}
+
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
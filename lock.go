@@ -0,0 +1,122 @@
+package scriptrunner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// LockInfo identifies the process holding a Lock.
+type LockInfo struct {
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+}
+
+// Lock is an exclusive lock file held by the current process.
+type Lock struct {
+	path string
+	file *os.File
+	Info LockInfo
+}
+
+// LockedError is returned when a lock is held by another running process.
+type LockedError struct {
+	Path string
+	Info LockInfo
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s is held by pid %d since %s", e.Path, e.Info.PID, e.Info.Started.Format(time.RFC3339))
+}
+
+// AcquireLock takes an exclusive advisory lock on the file at the given filepath and writes the pid and start time
+// of the current process into it. The operating system releases the lock when the holding process exits,
+// so a lock left behind by a process that is no longer running is acquired regardless of its contents.
+// If the lock is held by a running process, a *LockedError is returned.
+func AcquireLock(path string) (*Lock, error) {
+	L := Lock{
+		path: path,
+		Info: LockInfo{
+			PID:     os.Getpid(),
+			Started: time.Now().UTC(),
+		},
+	}
+	b, err := json.Marshal(L.Info)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling lock: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening lock file: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if err == errLockHeld {
+			info, _ := readLock(path)
+			return nil, &LockedError{Path: path, Info: info}
+		}
+		return nil, fmt.Errorf("error locking file: %w", err)
+	}
+	err = f.Truncate(0)
+	if err == nil {
+		_, err = f.WriteAt(b, 0)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		unlockFile(f)
+		f.Close()
+		return nil, fmt.Errorf("error writing lock file: %w", err)
+	}
+	L.file = f
+	return &L, nil
+}
+
+// WaitLock acquires the lock at the given filepath, retrying every poll interval while it is held
+// by another process, until ctx is canceled.
+func WaitLock(ctx context.Context, path string, poll time.Duration) (*Lock, error) {
+	for {
+		L, err := AcquireLock(path)
+		if _, locked := err.(*LockedError); !locked {
+			return L, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("error waiting for lock: %w", ctx.Err())
+		case <-time.After(poll):
+		}
+	}
+}
+
+// Release clears and unlocks the lock file. The file itself is left in place, as removing it would allow
+// a process waiting on the removed file and one creating a new file to both hold the lock.
+func (l *Lock) Release() error {
+	if l.file == nil {
+		return fmt.Errorf("lock file %s is not held", l.path)
+	}
+	defer func() {
+		l.file.Close()
+		l.file = nil
+	}()
+	if err := l.file.Truncate(0); err != nil {
+		return fmt.Errorf("error clearing lock file: %w", err)
+	}
+	if err := unlockFile(l.file); err != nil {
+		return fmt.Errorf("error unlocking file: %w", err)
+	}
+	return nil
+}
+
+// readLock returns the LockInfo stored in the lock file and whether it could be read.
+func readLock(path string) (LockInfo, bool) {
+	var info LockInfo
+	b, err := ioutil.ReadFile(path)
+	if err != nil || json.Unmarshal(b, &info) != nil || info.PID <= 0 {
+		return info, false
+	}
+	return info, true
+}
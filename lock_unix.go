@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package scriptrunner
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// errLockHeld is returned by lockFile when the lock is held by another process.
+var errLockHeld = errors.New("lock is held by another process")
+
+// lockFile takes an exclusive advisory lock on the given file without blocking.
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errLockHeld
+	}
+	return err
+}
+
+// unlockFile releases the lock taken by lockFile.
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
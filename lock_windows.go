@@ -0,0 +1,52 @@
+//go:build windows
+// +build windows
+
+package scriptrunner
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+	// lockOffsetHigh places the locked byte range far past the contents of the lock file,
+	// so other processes can still read the pid of the holder.
+	lockOffsetHigh = 0x40000000
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+// errLockHeld is returned by lockFile when the lock is held by another process.
+var errLockHeld = errors.New("lock is held by another process")
+
+// lockFile takes an exclusive lock on the given file without blocking.
+func lockFile(f *os.File) error {
+	ol := syscall.Overlapped{OffsetHigh: lockOffsetHigh}
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	switch {
+	case r != 0:
+		return nil
+	case err == errorLockViolation || err == syscall.ERROR_IO_PENDING:
+		return errLockHeld
+	}
+	return err
+}
+
+// unlockFile releases the lock taken by lockFile.
+func unlockFile(f *os.File) error {
+	ol := syscall.Overlapped{OffsetHigh: lockOffsetHigh}
+	r, _, err := procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package scriptrunner
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestFile is the name of the optional manifest at the root of an archive.
+const ManifestFile = `manifest.yaml`
+
+// Manifest defines the options an archive declares about itself.
+type Manifest struct {
+	Name      string        `yaml:"name,omitempty"`
+	Exclusive bool          `yaml:"exclusive,omitempty"`
+	RunPolicy RunPolicy     `yaml:"runPolicy,omitempty"`
+	Schedule  *ScheduleSpec `yaml:"schedule,omitempty"`
+	Selector  string        `yaml:"selector,omitempty"`
+	Template  bool          `yaml:"template,omitempty"`
+
+	Params map[string]string `yaml:"params,omitempty"`
+
+	RequiresMaintenanceWindow bool              `yaml:"requiresMaintenanceWindow,omitempty"`
+	DependsOn                 []string          `yaml:"dependsOn,omitempty"`
+	Include                   []string          `yaml:"include,omitempty"`
+	Exclude                   []string          `yaml:"exclude,omitempty"`
+	Recursive                 bool              `yaml:"recursive,omitempty"`
+	Scripts                   []ManifestScript  `yaml:"scripts,omitempty"`
+	Secrets                   []ManifestSecret  `yaml:"secrets,omitempty"`
+	Files                     map[string]string `yaml:"files,omitempty"`
+}
+
+// ManifestScript defines the options for a single script within an archive.
+// Name is the slash separated path of the script relative to the archive root.
+type ManifestScript struct {
+	Name      string   `yaml:"name"`
+	DependsOn []string `yaml:"dependsOn,omitempty"`
+}
+
+// ManifestSecret declares a secret the scripts of an archive require.
+// The secret is exposed through the environment variable Env, which defaults to the upper case Name.
+// If File is true, the value is written to a temporary file and the variable holds its path instead.
+type ManifestSecret struct {
+	Name string `yaml:"name"`
+	Env  string `yaml:"env,omitempty"`
+	File bool   `yaml:"file,omitempty"`
+}
+
+// EnvName returns the environment variable the secret is exposed through.
+func (s *ManifestSecret) EnvName() string {
+	if s.Env != "" {
+		return s.Env
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, s.Name)
+}
+
+// ReadManifest reads the manifest contained in the given archive file.
+// An empty Manifest is returned if the archive does not contain one.
+func ReadManifest(archive string) (*Manifest, error) {
+	var M Manifest
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return &M, fmt.Errorf("error opening archive file: %w", err)
+	}
+	defer r.Close()
+	if err := checkEntries(r.File); err != nil {
+		return &M, err
+	}
+	for _, f := range r.File {
+		if f.Name != ManifestFile {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return &M, fmt.Errorf("error opening manifest: %w", err)
+		}
+		defer rc.Close()
+		b, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return &M, fmt.Errorf("error reading manifest: %w", err)
+		}
+		if err := yaml.Unmarshal(b, &M); err != nil {
+			return &M, fmt.Errorf("error unmarshaling manifest: %w", err)
+		}
+		break
+	}
+	return &M, M.Validate()
+}
+
+// Validate returns an error if the Manifest contains invalid options.
+func (m *Manifest) Validate() error {
+	if !m.RunPolicy.Valid() {
+		return fmt.Errorf("invalid runPolicy %q", m.RunPolicy)
+	}
+	if m.Schedule != nil {
+		if _, err := m.Schedule.Parse(); err != nil {
+			return fmt.Errorf("invalid schedule: %w", err)
+		}
+	}
+	if m.Selector != "" {
+		if _, err := ParseSelector(m.Selector); err != nil {
+			return err
+		}
+	}
+	envs := make(map[string]bool, len(m.Secrets))
+	for _, s := range m.Secrets {
+		switch env := s.EnvName(); {
+		case s.Name == "":
+			return fmt.Errorf("secret name not specified")
+		case envs[env]:
+			return fmt.Errorf("duplicate secret env %q", env)
+		default:
+			envs[env] = true
+		}
+	}
+	return nil
+}
+
+// ScriptGraph returns a Graph of the given scripts and the dependencies declared between them.
+func (m *Manifest) ScriptGraph(scripts []string) *Graph {
+	g := NewGraph()
+	for _, s := range scripts {
+		g.AddNode(s)
+	}
+	for _, s := range m.Scripts {
+		if !g.HasNode(s.Name) {
+			continue
+		}
+		for _, d := range s.DependsOn {
+			g.AddDependency(s.Name, d)
+		}
+	}
+	return g
+}
@@ -2,6 +2,8 @@ package scriptrunner
 
 import (
 	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -110,14 +112,14 @@ func UnZip(srcFile, dstDir string) error {
 
 	for _, f := range archive.File {
 		filePath := filepath.Join(dstDir, f.Name)
-		fmt.Println("unzipping file ", filePath)
 
 		if !strings.HasPrefix(filePath, filepath.Clean(dstDir)+string(os.PathSeparator)) {
 			return fmt.Errorf("invalid file path")
 		}
 		if f.FileInfo().IsDir() {
-			fmt.Println("creating directory...")
-			os.MkdirAll(filePath, os.ModePerm)
+			if err := os.MkdirAll(filePath, os.ModePerm); err != nil {
+				return fmt.Errorf("error creating directory: %w", err)
+			}
 			continue
 		}
 
@@ -173,3 +175,17 @@ func CreateDir(path string) error {
 	}
 	return nil
 }
+
+// FileHash returns the hex encoded SHA-256 hash of the given file.
+func FileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,23 @@
+//go:build windows
+// +build windows
+
+package scriptrunner
+
+import "syscall"
+
+// stillActive is the exit code reported for a process that has not exited.
+const stillActive = 259
+
+// processAlive returns true if a process with the given pid is running.
+func processAlive(pid int) bool {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
@@ -0,0 +1,261 @@
+package scriptrunner
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SignatureFile is the name of the optional signature of the manifest at the root of an archive.
+const SignatureFile = `manifest.sig`
+
+// PackArchive creates an archive at the given filepath from the contents of dir.
+// The manifest within dir is validated, or generated if it does not exist, and the hash of every
+// file is embedded in it. If key is not nil, the manifest is signed. The packed Manifest is returned.
+func PackArchive(dir, out string, key ed25519.PrivateKey) (*Manifest, error) {
+	var M Manifest
+	b, err := ioutil.ReadFile(filepath.Join(dir, ManifestFile))
+	switch {
+	case os.IsNotExist(err):
+		M.Name = filepath.Base(filepath.Clean(dir))
+	case err != nil:
+		return &M, fmt.Errorf("error reading manifest: %w", err)
+	default:
+		if err := yaml.Unmarshal(b, &M); err != nil {
+			return &M, fmt.Errorf("error unmarshaling manifest: %w", err)
+		}
+	}
+	if err := M.Validate(); err != nil {
+		return &M, err
+	}
+
+	scripts, err := DiscoverScripts(dir, M.Include, M.Exclude, M.Recursive)
+	if err != nil {
+		return &M, err
+	}
+	if len(scripts) == 0 {
+		return &M, fmt.Errorf("no scripts found in %q", dir)
+	}
+	if len(M.Scripts) == 0 {
+		for _, s := range scripts {
+			M.Scripts = append(M.Scripts, ManifestScript{Name: s})
+		}
+	}
+	g := M.ScriptGraph(scripts)
+	for _, s := range M.Scripts {
+		if !g.HasNode(s.Name) {
+			return &M, fmt.Errorf("manifest script %q not found", s.Name)
+		}
+		if missing := g.Missing(s.Name); len(missing) > 0 {
+			return &M, fmt.Errorf("manifest script %q depends on missing scripts: %v", s.Name, missing)
+		}
+	}
+	if _, err := g.Sort(); err != nil {
+		return &M, err
+	}
+
+	files, err := packFiles(dir, out)
+	if err != nil {
+		return &M, err
+	}
+	M.Files = make(map[string]string, len(files))
+	for _, f := range files {
+		hash, err := FileHash(filepath.Join(dir, filepath.FromSlash(f)))
+		if err != nil {
+			return &M, fmt.Errorf("error hashing %q: %w", f, err)
+		}
+		M.Files[f] = hash
+	}
+	manifest, err := yaml.Marshal(&M)
+	if err != nil {
+		return &M, fmt.Errorf("error marshaling manifest: %w", err)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if err := writeZipFile(zw, ManifestFile, bytes.NewReader(manifest)); err != nil {
+		return &M, err
+	}
+	if key != nil {
+		sig := base64.StdEncoding.EncodeToString(ed25519.Sign(key, manifest))
+		if err := writeZipFile(zw, SignatureFile, strings.NewReader(sig)); err != nil {
+			return &M, err
+		}
+	}
+	for _, f := range files {
+		src, err := os.Open(filepath.Join(dir, filepath.FromSlash(f)))
+		if err != nil {
+			return &M, fmt.Errorf("error opening %q: %w", f, err)
+		}
+		err = writeZipFile(zw, f, src)
+		src.Close()
+		if err != nil {
+			return &M, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return &M, fmt.Errorf("error finalizing archive: %w", err)
+	}
+	return &M, WriteFileAtomic(out, buf.Bytes(), 0644)
+}
+
+// VerifyArchive verifies the given archive file against the hashes embedded in its Manifest.
+// If key is not nil, the archive must also contain a valid signature of its manifest.
+// Archives without embedded hashes are only accepted when key is nil.
+// The verified Manifest is returned and is the only one that should be trusted.
+func VerifyArchive(archive string, key ed25519.PublicKey) (*Manifest, error) {
+	var M Manifest
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return &M, fmt.Errorf("error opening archive file: %w", err)
+	}
+	defer r.Close()
+	if err := checkEntries(r.File); err != nil {
+		return &M, err
+	}
+	var manifest, sig []byte
+	hashes := make(map[string]string, len(r.File))
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return &M, fmt.Errorf("error opening %q: %w", f.Name, err)
+		}
+		switch f.Name {
+		case ManifestFile:
+			manifest, err = ioutil.ReadAll(rc)
+		case SignatureFile:
+			sig, err = ioutil.ReadAll(rc)
+		default:
+			h := sha256.New()
+			_, err = io.Copy(h, rc)
+			hashes[f.Name] = hex.EncodeToString(h.Sum(nil))
+		}
+		rc.Close()
+		if err != nil {
+			return &M, fmt.Errorf("error reading %q: %w", f.Name, err)
+		}
+	}
+
+	if key != nil {
+		if manifest == nil || sig == nil {
+			return &M, fmt.Errorf("archive is not signed")
+		}
+		s, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+		if err != nil {
+			return &M, fmt.Errorf("error decoding signature: %w", err)
+		}
+		if !ed25519.Verify(key, manifest, s) {
+			return &M, fmt.Errorf("invalid archive signature")
+		}
+	}
+
+	if err := yaml.Unmarshal(manifest, &M); err != nil {
+		return &M, fmt.Errorf("error unmarshaling manifest: %w", err)
+	}
+	if err := M.Validate(); err != nil {
+		return &M, err
+	}
+	if len(M.Files) == 0 {
+		if key != nil {
+			return &M, fmt.Errorf("signed manifest contains no file hashes")
+		}
+		return &M, nil
+	}
+	for name, hash := range hashes {
+		expected, ok := M.Files[name]
+		switch {
+		case !ok:
+			return &M, fmt.Errorf("file %q is not listed in manifest", name)
+		case expected != hash:
+			return &M, fmt.Errorf("hash mismatch for %q", name)
+		}
+	}
+	for name := range M.Files {
+		if _, ok := hashes[name]; !ok {
+			return &M, fmt.Errorf("file %q listed in manifest is missing", name)
+		}
+	}
+	return &M, nil
+}
+
+// checkEntries returns an error if more than one entry of an archive resolves to the same path.
+func checkEntries(files []*zip.File) error {
+	seen := make(map[string]bool, len(files))
+	for _, f := range files {
+		name := path.Clean(strings.ReplaceAll(f.Name, `\`, `/`))
+		if seen[name] {
+			return fmt.Errorf("archive contains duplicate entry %q", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// packFiles returns the slash separated paths, relative to dir, of all files to include in an archive,
+// excluding the archive being created.
+func packFiles(dir, out string) ([]string, error) {
+	var files []string
+	outPath, err := filepath.Abs(out)
+	if err != nil {
+		return nil, err
+	}
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == `.git` {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		switch {
+		case rel == ManifestFile, rel == SignatureFile, abs == outPath:
+		default:
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing files: %w", err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func writeZipFile(zw *zip.Writer, name string, r io.Reader) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return fmt.Errorf("error adding %q to archive: %w", name, err)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("error writing %q to archive: %w", name, err)
+	}
+	return nil
+}
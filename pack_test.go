@@ -0,0 +1,86 @@
+package scriptrunner
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyArchiveDuplicateManifest(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, `src`)
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, `run.ps1`), []byte(`Write-Output "ok"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := filepath.Join(dir, `signed.zip`)
+	if _, err := PackArchive(src, signed, priv); err != nil {
+		t.Fatal(err)
+	}
+	m, err := VerifyArchive(signed, pub)
+	if err != nil {
+		t.Fatalf("signed archive failed verification: %v", err)
+	}
+	if _, ok := m.Files[`run.ps1`]; !ok {
+		t.Fatalf("verified manifest does not list run.ps1: %v", m.Files)
+	}
+
+	// Prepend an unsigned manifest to an otherwise valid signed archive.
+	tampered := filepath.Join(dir, `tampered.zip`)
+	out, err := os.Create(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(out)
+	w, err := zw.Create(ManifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("name: unsigned\n")); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.OpenReader(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		w, err := zw.Create(f.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.Copy(w, rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+
+	if _, err := VerifyArchive(tampered, pub); err == nil {
+		t.Error("archive with duplicate manifest passed signature verification")
+	}
+	if _, err := VerifyArchive(tampered, nil); err == nil {
+		t.Error("archive with duplicate manifest passed hash verification")
+	}
+	if _, err := ReadManifest(tampered); err == nil {
+		t.Error("manifest read from archive with duplicate manifest")
+	}
+}
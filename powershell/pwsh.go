@@ -2,29 +2,72 @@ package powershell
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"os"
 	"os/exec"
+	"time"
 )
 
 // PowerShell struct
 type PowerShell struct {
 	powerShell string
 	workDir    string
+	timeout    time.Duration
+	env        []string
 }
 
 // New create new session
 func New(workDir string) *PowerShell {
-	ps, _ := exec.LookPath("powershell.exe")
+	return NewWithPath("", workDir)
+}
+
+// NewWithPath creates a new session using the given PowerShell executable, or powershell.exe if empty.
+// Executable names without a path are looked up in the PATH.
+func NewWithPath(exe, workDir string) *PowerShell {
+	switch {
+	case exe == "":
+		exe, _ = exec.LookPath("powershell.exe")
+	default:
+		if path, err := exec.LookPath(exe); err == nil {
+			exe = path
+		}
+	}
 	return &PowerShell{
-		powerShell: ps,
+		powerShell: exe,
 		workDir:    workDir,
 	}
 }
 
+// SetTimeout sets the time each execution may take before it is killed. Zero means no limit.
+func (p *PowerShell) SetTimeout(timeout time.Duration) {
+	p.timeout = timeout
+}
+
+// SetEnv sets additional "KEY=value" environment variables for each execution.
+func (p *PowerShell) SetEnv(env []string) {
+	p.env = env
+}
+
+// Path returns the resolved path of the PowerShell executable, or an empty string if it was not found.
+func (p *PowerShell) Path() string {
+	return p.powerShell
+}
+
 // Execute runs the given command arguments using Powershell.
 func (p *PowerShell) Execute(args ...string) (stdOut string, stdErr string, err error) {
+	ctx := context.Background()
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
 	args = append([]string{"-NoProfile", "-NonInteractive"}, args...)
-	cmd := exec.Command(p.powerShell, args...)
+	cmd := exec.CommandContext(ctx, p.powerShell, args...)
 	cmd.Dir = p.workDir
+	if len(p.env) > 0 {
+		cmd.Env = append(os.Environ(), p.env...)
+	}
 
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
@@ -32,6 +75,9 @@ func (p *PowerShell) Execute(args ...string) (stdOut string, stdErr string, err
 	cmd.Stderr = &stderr
 
 	err = cmd.Run()
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s: %w", p.timeout, err)
+	}
 	stdOut, stdErr = stdout.String(), stderr.String()
 	return
 }
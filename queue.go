@@ -0,0 +1,195 @@
+package scriptrunner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	queueExt = `.json`
+	// RejectedDir is the directory within the queue directory holding the reports HomeBase rejected.
+	RejectedDir = `rejected`
+)
+
+// QueueConfig defines the local queue holding reports until HomeBase receives them. Zero limits are unlimited.
+type QueueConfig struct {
+	Dir       string        `yaml:"dir"`
+	MaxCount  int           `yaml:"maxCount"`
+	MaxAge    time.Duration `yaml:"maxAge"`
+	MaxSizeMB int64         `yaml:"maxSizeMB"`
+}
+
+// RejectedError is returned by the send function given to Replay when HomeBase rejected a report,
+// so that sending it again would never succeed.
+type RejectedError struct {
+	Err error
+}
+
+func (e *RejectedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+// ReportQueue persists RunReports within a directory, one file per run ID, until they are delivered.
+// Queued reports are named by their run ID and so are replayed in the order they were run.
+type ReportQueue struct {
+	config QueueConfig
+	lock   sync.Mutex
+}
+
+// NewReportQueue returns a ReportQueue using the given QueueConfig, creating its directory if needed.
+func NewReportQueue(config QueueConfig) (*ReportQueue, error) {
+	if err := createPrivateDir(config.Dir); err != nil {
+		return nil, fmt.Errorf("error creating queue directory: %w", err)
+	}
+	return &ReportQueue{config: config}, nil
+}
+
+// Push adds the RunReport to the queue, replacing any queued report with the same run ID.
+func (q *ReportQueue) Push(r *RunReport) error {
+	if r.RunID == "" || r.RunID != filepath.Base(r.RunID) || strings.HasPrefix(r.RunID, `.`) {
+		return fmt.Errorf("invalid run ID %q", r.RunID)
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("error marshaling report: %w", err)
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if err := WriteFileAtomic(filepath.Join(q.config.Dir, r.RunID+queueExt), b, 0600); err != nil {
+		return fmt.Errorf("error queuing report: %w", err)
+	}
+	return nil
+}
+
+// Len returns the number of queued reports.
+func (q *ReportQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	files, _ := q.files()
+	return len(files)
+}
+
+// Replay sends the queued reports in order, removing each once sent, and returns the run IDs sent and rejected.
+// Replay stops at the first report that cannot be sent so that the order is kept for the next attempt.
+// Reports that send rejects with a *RejectedError are moved to the RejectedDir and skipped, so they do not
+// hold back the rest of the queue. Reports that can no longer be read are removed.
+func (q *ReportQueue) Replay(send func(*RunReport) error) ([]string, []string, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	files, err := q.files()
+	if err != nil {
+		return nil, nil, err
+	}
+	var sent, rejected, invalid []string
+	for _, f := range files {
+		path := filepath.Join(q.config.Dir, f.Name())
+		var report RunReport
+		b, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(b, &report)
+		}
+		if err != nil {
+			invalid = append(invalid, f.Name())
+			os.Remove(path)
+			continue
+		}
+		err = send(&report)
+		var rejectedErr *RejectedError
+		switch {
+		case errors.As(err, &rejectedErr):
+			if err := q.reject(f.Name()); err != nil {
+				return sent, rejected, err
+			}
+			rejected = append(rejected, strings.TrimSuffix(f.Name(), queueExt))
+			continue
+		case err != nil:
+			return sent, rejected, err
+		}
+		if err := os.Remove(path); err != nil {
+			return sent, rejected, fmt.Errorf("error removing sent report: %w", err)
+		}
+		sent = append(sent, report.RunID)
+	}
+	if len(invalid) > 0 {
+		return sent, rejected, fmt.Errorf("removed %d unreadable queued reports: %v", len(invalid), invalid)
+	}
+	return sent, rejected, nil
+}
+
+// reject moves the named queued report into the RejectedDir of the queue.
+func (q *ReportQueue) reject(name string) error {
+	dir := filepath.Join(q.config.Dir, RejectedDir)
+	if err := createPrivateDir(dir); err != nil {
+		return fmt.Errorf("error creating rejected reports directory: %w", err)
+	}
+	if err := os.Rename(filepath.Join(q.config.Dir, name), filepath.Join(dir, name)); err != nil {
+		return fmt.Errorf("error moving rejected report: %w", err)
+	}
+	return nil
+}
+
+// Prune removes the oldest queued reports exceeding any of the configured limits and returns their run IDs.
+func (q *ReportQueue) Prune() ([]string, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	files, err := q.files()
+	if err != nil {
+		return nil, err
+	}
+	var removed, errFiles []string
+	var errd error
+	var count int
+	var size int64
+	for i := len(files) - 1; i >= 0; i-- {
+		f := files[i]
+		count++
+		size += f.Size()
+		switch {
+		case q.config.MaxCount > 0 && count > q.config.MaxCount:
+		case q.config.MaxAge > 0 && time.Since(f.ModTime()) > q.config.MaxAge:
+		case q.config.MaxSizeMB > 0 && size > q.config.MaxSizeMB*1024*1024:
+		default:
+			continue
+		}
+		if err := os.Remove(filepath.Join(q.config.Dir, f.Name())); err != nil {
+			errd = err
+			errFiles = append(errFiles, f.Name())
+			continue
+		}
+		removed = append(removed, strings.TrimSuffix(f.Name(), queueExt))
+	}
+	if len(errFiles) > 0 {
+		return removed, fmt.Errorf("error deleting %d queued reports: %v : lasterr: %v", len(errFiles), errFiles, errd)
+	}
+	return removed, nil
+}
+
+// files returns the queued report files, oldest first.
+func (q *ReportQueue) files() ([]os.FileInfo, error) {
+	entries, err := ioutil.ReadDir(q.config.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading queue directory: %w", err)
+	}
+	files := make([]os.FileInfo, 0, len(entries))
+	for _, f := range entries {
+		if f.Mode().IsRegular() && filepath.Ext(f.Name()) == queueExt && !strings.HasPrefix(f.Name(), `.`) {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+	return files, nil
+}
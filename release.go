@@ -0,0 +1,177 @@
+package scriptrunner
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ReleaseFile is the name of the file describing the latest release within each <os>/<arch> directory of the releases directory.
+const ReleaseFile = `release.json`
+
+var platformRegexp = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// Release describes a client build offered by HomeBase for an OS and architecture.
+// The signature covers the version, platform and SHA-256 hash of the binary, and so the binary itself.
+type Release struct {
+	Version   string    `json:"version"`
+	OS        string    `json:"os"`
+	Arch      string    `json:"arch"`
+	File      string    `json:"file"`
+	SHA256    string    `json:"sha256"`
+	Signature string    `json:"signature"`
+	Published time.Time `json:"published"`
+}
+
+// ValidPlatform returns true if goos and goarch are safe to use as directory names, such as "windows" and "amd64".
+func ValidPlatform(goos, goarch string) bool {
+	return platformRegexp.MatchString(goos) && platformRegexp.MatchString(goarch)
+}
+
+// CompareVersions returns -1, 0 or 1 if version a is older than, equal to or newer than version b.
+// Runs of digits are compared numerically and anything else lexically, so that semantic versions such as
+// v1.10.0 and build timestamps such as 2021.10.20.150405 are ordered as expected. A version followed by
+// a prerelease suffix starting with a hyphen, such as 1.2.0-rc1, is older than the version itself.
+func CompareVersions(a, b string) int {
+	for a != "" && b != "" {
+		var x, y string
+		x, a = versionPart(a)
+		y, b = versionPart(b)
+		if isDigit(x[0]) && isDigit(y[0]) {
+			x, y = strings.TrimLeft(x, `0`), strings.TrimLeft(y, `0`)
+			if len(x) != len(y) {
+				if len(x) < len(y) {
+					return -1
+				}
+				return 1
+			}
+		}
+		if c := strings.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "" && strings.HasPrefix(b, `-`):
+		return 1
+	case a == "":
+		return -1
+	case strings.HasPrefix(a, `-`):
+		return -1
+	}
+	return 1
+}
+
+// versionPart splits the leading run of digits or non-digits from the given version.
+func versionPart(v string) (string, string) {
+	i := 1
+	for i < len(v) && isDigit(v[i]) == isDigit(v[0]) {
+		i++
+	}
+	return v[:i], v[i:]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Sign signs the Release using the given key.
+func (r *Release) Sign(key ed25519.PrivateKey) {
+	r.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, r.payload()))
+}
+
+// Verify returns an error if the Release is not signed by the given key.
+func (r *Release) Verify(key ed25519.PublicKey) error {
+	sig, err := base64.StdEncoding.DecodeString(r.Signature)
+	if err != nil {
+		return fmt.Errorf("error decoding release signature: %w", err)
+	}
+	if !ed25519.Verify(key, r.payload(), sig) {
+		return fmt.Errorf("invalid release signature")
+	}
+	return nil
+}
+
+func (r *Release) payload() []byte {
+	return []byte(fmt.Sprintf("scriptrunner release\n%s\n%s\n%s\n%s\n", r.Version, r.OS, r.Arch, r.SHA256))
+}
+
+// LoadRelease reads the Release stored as JSON at the given filepath.
+func LoadRelease(path string) (*Release, error) {
+	var R Release
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return &R, fmt.Errorf("error reading release file: %w", err)
+	}
+	if err := json.Unmarshal(b, &R); err != nil {
+		return &R, fmt.Errorf("error unmarshaling release file: %w", err)
+	}
+	return &R, nil
+}
+
+// PublishRelease copies the binary into the <os>/<arch> directory of the releases directory and
+// writes its signed Release there, replacing the previous latest release for the platform.
+func PublishRelease(binary, dir, version, goos, goarch string, key ed25519.PrivateKey) (*Release, error) {
+	if !ValidPlatform(goos, goarch) {
+		return nil, fmt.Errorf("invalid platform %s/%s", goos, goarch)
+	}
+	R := Release{
+		Version:   version,
+		OS:        goos,
+		Arch:      goarch,
+		File:      `scriptrunner-` + version,
+		Published: time.Now().UTC(),
+	}
+	if goos == `windows` {
+		R.File += `.exe`
+	}
+	if R.File != filepath.Base(R.File) {
+		return nil, fmt.Errorf("invalid version %q", version)
+	}
+	platformDir := filepath.Join(dir, goos, goarch)
+	if err := os.MkdirAll(platformDir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating release directory: %w", err)
+	}
+	if err := copyFile(binary, filepath.Join(platformDir, R.File), 0755); err != nil {
+		return nil, err
+	}
+	var err error
+	if R.SHA256, err = FileHash(filepath.Join(platformDir, R.File)); err != nil {
+		return nil, fmt.Errorf("error hashing release: %w", err)
+	}
+	R.Sign(key)
+	b, err := json.MarshalIndent(&R, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling release: %w", err)
+	}
+	if err := WriteFileAtomic(filepath.Join(platformDir, ReleaseFile), b, 0644); err != nil {
+		return nil, fmt.Errorf("error writing release file: %w", err)
+	}
+	return &R, nil
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("error opening %q: %w", src, err)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return fmt.Errorf("error creating %q: %w", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("error copying %q: %w", src, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,34 @@
+package scriptrunner
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.2.3", "v1.2.3", 0},
+		{"v1.10.0", "v1.9.0", 1},
+		{"v1.9.0", "v1.10.0", -1},
+		{"1.2", "1.2.1", -1},
+		{"1.2.1", "1.2", 1},
+		{"v1.02", "v1.2", 0},
+		{"2021.10.20.150405", "2021.10.20.150405", 0},
+		{"2021.10.21.000000", "2021.10.20.235959", 1},
+		{"2021-10-20T15:04:05Z", "2021-10-20T15:04:06Z", -1},
+		{"", "", 0},
+		{"", "v1.0.0", -1},
+		{"v1.0.0", "", 1},
+		{"1.2.0-rc1", "1.2.0", -1},
+		{"1.2.0", "1.2.0-rc1", 1},
+		{"1.2.0-rc1", "1.2.0-rc2", -1},
+		{"1.2.0-rc10", "1.2.0-rc9", 1},
+		{"1.2.0-beta", "1.2.0-alpha", 1},
+		{"1.3.0", "1.2.0-rc1", 1},
+	}
+	for _, tt := range tests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
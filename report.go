@@ -0,0 +1,92 @@
+package scriptrunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxReportOutput is the number of bytes of script output kept in a report.
+const MaxReportOutput = 4096
+
+// ReportFormat is the format a RunReport is written in.
+type ReportFormat string
+
+// Available ReportFormats.
+const (
+	ReportJSON  ReportFormat = `json`
+	ReportJUnit ReportFormat = `junit`
+)
+
+// Valid returns true if the ReportFormat is known.
+func (f ReportFormat) Valid() bool {
+	switch f {
+	case ReportJSON, ReportJUnit:
+		return true
+	}
+	return false
+}
+
+// RunReport contains the results of a single client run.
+type RunReport struct {
+	RunID    string           `json:"runID"`
+	Host     string           `json:"host"`
+	Client   string           `json:"client,omitempty"`
+	Started  time.Time        `json:"started"`
+	Finished time.Time        `json:"finished"`
+	Archives []*ArchiveResult `json:"archives"`
+}
+
+// ArchiveResult contains the outcome of an archive and its scripts.
+type ArchiveResult struct {
+	Archive  string          `json:"archive"`
+	Name     string          `json:"name"`
+	Status   Status          `json:"status"`
+	Reason   string          `json:"reason,omitempty"`
+	Started  time.Time       `json:"started"`
+	Duration time.Duration   `json:"duration"`
+	Scripts  []*ScriptResult `json:"scripts,omitempty"`
+}
+
+// ScriptResult contains the outcome of a single script.
+type ScriptResult struct {
+	Script     string        `json:"script"`
+	Status     Status        `json:"status"`
+	Reason     string        `json:"reason,omitempty"`
+	ExitCode   int           `json:"exitCode"`
+	Started    time.Time     `json:"started"`
+	Duration   time.Duration `json:"duration"`
+	Output     string        `json:"output,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Truncated  bool          `json:"truncated,omitempty"`
+	OutputFile string        `json:"outputFile,omitempty"`
+}
+
+// Write writes the RunReport to w in the given ReportFormat.
+func (r *RunReport) Write(w io.Writer, format ReportFormat) error {
+	switch format {
+	case ReportJSON:
+		b, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error marshaling report: %w", err)
+		}
+		_, err = w.Write(append(b, '\n'))
+		return err
+	case ReportJUnit:
+		return r.WriteJUnit(w)
+	}
+	return fmt.Errorf("invalid report format %q", format)
+}
+
+// Truncate returns at most the first max bytes of s, without splitting a character, and whether s was truncated.
+func Truncate(s string, max int) (string, bool) {
+	if len(s) <= max {
+		return s, false
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max], true
+}
@@ -0,0 +1,80 @@
+package scriptrunner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CatchUp determines how a schedule handles runs missed while the client was not running.
+type CatchUp string
+
+// Available CatchUp policies.
+const (
+	// CatchUpOnce runs a single time for any number of missed runs.
+	CatchUpOnce CatchUp = `once`
+	// CatchUpSkip ignores missed runs and waits for the next scheduled time.
+	CatchUpSkip CatchUp = `skip`
+)
+
+// ScheduleSpec defines when an archive runs in daemon mode.
+// An empty Timezone uses the local timezone and an empty CatchUp uses CatchUpOnce.
+type ScheduleSpec struct {
+	Cron     string  `yaml:"cron"`
+	Timezone string  `yaml:"timezone,omitempty"`
+	CatchUp  CatchUp `yaml:"catchUp,omitempty"`
+}
+
+// Parse returns the CronSchedule of the ScheduleSpec.
+func (s *ScheduleSpec) Parse() (*CronSchedule, error) {
+	switch s.CatchUp {
+	case "", CatchUpOnce, CatchUpSkip:
+	default:
+		return nil, fmt.Errorf("invalid catchUp %q", s.CatchUp)
+	}
+	loc := time.Local
+	if s.Timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(s.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", s.Timezone, err)
+		}
+	}
+	return ParseCron(s.Cron, loc)
+}
+
+// Due returns whether an archive last run at lastRun is due at now, along with the scheduled time it is due for
+// or will next be due. since is when schedules were last evaluated, such as the start of the previous daemon cycle.
+// Scheduled times before since were missed while the client was not running and are handled by the CatchUp policy.
+func (s *ScheduleSpec) Due(lastRun, since, now time.Time) (bool, time.Time, error) {
+	c, err := s.Parse()
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	from := lastRun
+	if from.IsZero() || (s.CatchUp == CatchUpSkip && from.Before(since)) {
+		from = since
+	}
+	next := c.Next(from)
+	return !next.IsZero() && !next.After(now), next, nil
+}
+
+// LoadSchedules reads a central schedule file mapping archive names to their ScheduleSpec.
+func LoadSchedules(path string) (map[string]ScheduleSpec, error) {
+	schedules := make(map[string]ScheduleSpec)
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return schedules, fmt.Errorf("error reading schedule file: %w", err)
+	}
+	if err := yaml.Unmarshal(b, &schedules); err != nil {
+		return schedules, fmt.Errorf("error unmarshaling schedule file: %w", err)
+	}
+	for name, s := range schedules {
+		if _, err := s.Parse(); err != nil {
+			return schedules, fmt.Errorf("invalid schedule for %q: %w", name, err)
+		}
+	}
+	return schedules, nil
+}
@@ -0,0 +1,92 @@
+package scriptrunner
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// ScrubMask replaces secret values scrubbed from output.
+const ScrubMask = `********`
+
+// MinScrubLength is the length below which secret values are not scrubbed, as masking every occurrence
+// of a value such as "1" or "no" would leave logs and reports unreadable.
+const MinScrubLength = 4
+
+// Scrubber masks secret values within text such as logs, script output and reports.
+type Scrubber struct {
+	lock   sync.RWMutex
+	values []string
+}
+
+// Add registers secret values to be scrubbed, including their JSON escaped form.
+// Values shorter than MinScrubLength are ignored.
+func (s *Scrubber) Add(values ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, v := range values {
+		if len(v) < MinScrubLength {
+			continue
+		}
+		s.values = append(s.values, v)
+		if b, err := json.Marshal(v); err == nil {
+			if escaped := string(b[1 : len(b)-1]); escaped != v {
+				s.values = append(s.values, escaped)
+			}
+		}
+	}
+}
+
+// Scrub returns str with every registered secret value masked.
+func (s *Scrubber) Scrub(str string) string {
+	if s == nil {
+		return str
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, v := range s.values {
+		str = strings.ReplaceAll(str, v, ScrubMask)
+	}
+	return str
+}
+
+// ScrubReport masks the registered secret values within the reasons, output and errors of the RunReport.
+func (s *Scrubber) ScrubReport(r *RunReport) {
+	for _, a := range r.Archives {
+		a.Reason = s.Scrub(a.Reason)
+		for _, sr := range a.Scripts {
+			sr.Reason = s.Scrub(sr.Reason)
+			sr.Output = s.Scrub(sr.Output)
+			sr.Error = s.Scrub(sr.Error)
+		}
+	}
+}
+
+// WriteSyncer returns a zapcore.WriteSyncer scrubbing the registered secret values from everything written to ws.
+func (s *Scrubber) WriteSyncer(ws zapcore.WriteSyncer) zapcore.WriteSyncer {
+	return &scrubWriter{ws: ws, scrubber: s}
+}
+
+type scrubWriter struct {
+	ws       zapcore.WriteSyncer
+	scrubber *Scrubber
+}
+
+func (w *scrubWriter) Write(p []byte) (int, error) {
+	w.scrubber.lock.RLock()
+	n := len(w.scrubber.values)
+	w.scrubber.lock.RUnlock()
+	if n == 0 {
+		return w.ws.Write(p)
+	}
+	if _, err := w.ws.Write([]byte(w.scrubber.Scrub(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *scrubWriter) Sync() error {
+	return w.ws.Sync()
+}
@@ -0,0 +1,240 @@
+package scriptrunner
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sort"
+)
+
+// Secret envelope algorithms.
+const (
+	// SecretRSA wraps a random AES-256-GCM key with RSA-OAEP using SHA-256.
+	SecretRSA = `RSA-OAEP-256+A256GCM`
+	// SecretECDH derives an AES-256-GCM key from an ephemeral ECDH exchange hashed with SHA-256.
+	SecretECDH = `ECDH-ES+A256GCM`
+)
+
+// SecretEnvelope is a secret value encrypted to the public key of a client certificate.
+// The secret name is bound to the envelope as additional authenticated data.
+type SecretEnvelope struct {
+	Algorithm  string `json:"alg"`
+	Key        string `json:"key"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// Secrets maps secret names to their SecretEnvelope.
+type Secrets map[string]*SecretEnvelope
+
+// EncryptSecret encrypts the named secret value to the given RSA or ECDSA public key.
+func EncryptSecret(pub crypto.PublicKey, name string, value []byte) (*SecretEnvelope, error) {
+	var E SecretEnvelope
+	var key []byte
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("error generating key: %w", err)
+		}
+		wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, []byte(name))
+		if err != nil {
+			return nil, fmt.Errorf("error wrapping key: %w", err)
+		}
+		E.Algorithm, E.Key = SecretRSA, base64.StdEncoding.EncodeToString(wrapped)
+	case *ecdsa.PublicKey:
+		eph, err := ecdsa.GenerateKey(pub.Curve, rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("error generating ephemeral key: %w", err)
+		}
+		ephPub := elliptic.Marshal(pub.Curve, eph.X, eph.Y)
+		key = deriveECDHKey(pub.Curve, pub.X, pub.Y, eph.D, ephPub)
+		E.Algorithm, E.Key = SecretECDH, base64.StdEncoding.EncodeToString(ephPub)
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+	E.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	E.Ciphertext = base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, value, []byte(name)))
+	return &E, nil
+}
+
+// Decrypt decrypts the named secret using the private key it was encrypted to.
+func (e *SecretEnvelope) Decrypt(priv crypto.PrivateKey, name string) ([]byte, error) {
+	keyData, err := base64.StdEncoding.DecodeString(e.Key)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding key: %w", err)
+	}
+	var key []byte
+	switch priv := priv.(type) {
+	case *rsa.PrivateKey:
+		if e.Algorithm != SecretRSA {
+			return nil, fmt.Errorf("algorithm %q does not match rsa key", e.Algorithm)
+		}
+		key, err = rsa.DecryptOAEP(sha256.New(), nil, priv, keyData, []byte(name))
+		if err != nil {
+			return nil, fmt.Errorf("error unwrapping key: %w", err)
+		}
+	case *ecdsa.PrivateKey:
+		if e.Algorithm != SecretECDH {
+			return nil, fmt.Errorf("algorithm %q does not match ecdsa key", e.Algorithm)
+		}
+		x, y := elliptic.Unmarshal(priv.Curve, keyData)
+		if x == nil {
+			return nil, fmt.Errorf("invalid ephemeral key")
+		}
+		key = deriveECDHKey(priv.Curve, x, y, priv.D, keyData)
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", priv)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(e.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding nonce: %w", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(e.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding ciphertext: %w", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce size %d", len(nonce))
+	}
+	value, err := gcm.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting secret: %w", err)
+	}
+	return value, nil
+}
+
+// Decrypt decrypts every secret using the given private key and returns the values by name.
+func (s Secrets) Decrypt(priv crypto.PrivateKey) (map[string]string, error) {
+	values := make(map[string]string, len(s))
+	for name, e := range s {
+		v, err := e.Decrypt(priv, name)
+		if err != nil {
+			return nil, fmt.Errorf("secret %q: %w", name, err)
+		}
+		values[name] = string(v)
+	}
+	return values, nil
+}
+
+// Names returns the sorted names of the secrets.
+func (s Secrets) Names() []string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadSecrets reads the Secrets stored as JSON at the given filepath.
+// An empty Secrets is returned if the file does not exist.
+func LoadSecrets(path string) (Secrets, error) {
+	S := make(Secrets)
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return S, nil
+	case err != nil:
+		return S, fmt.Errorf("error reading secrets file: %w", err)
+	}
+	if err := json.Unmarshal(b, &S); err != nil {
+		return S, fmt.Errorf("error unmarshaling secrets file: %w", err)
+	}
+	return S, nil
+}
+
+// Save writes the Secrets as JSON to the given filepath, readable only by the owner.
+func (s Secrets) Save(path string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling secrets: %w", err)
+	}
+	return WriteFileAtomic(path, b, 0600)
+}
+
+// LoadPrivateKey reads a PEM encoded PKCS #8, PKCS #1 or SEC 1 private key from the given filepath.
+func LoadPrivateKey(path string) (crypto.PrivateKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading private key: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %q", path)
+	}
+	switch block.Type {
+	case `RSA PRIVATE KEY`:
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case `EC PRIVATE KEY`:
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	return x509.ParsePKCS8PrivateKey(block.Bytes)
+}
+
+// LoadPublicKey reads the public key of a PEM encoded certificate or PKIX public key from the given filepath.
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading public key: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %q", path)
+	}
+	if block.Type == `CERTIFICATE` {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing certificate: %w", err)
+		}
+		return cert.PublicKey, nil
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func deriveECDHKey(curve elliptic.Curve, x, y, d *big.Int, ephPub []byte) []byte {
+	sx, _ := curve.ScalarMult(x, y, d.Bytes())
+	shared := make([]byte, (curve.Params().BitSize+7)/8)
+	sx.FillBytes(shared)
+	h := sha256.New()
+	h.Write(shared)
+	h.Write(ephPub)
+	return h.Sum(nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cipher: %w", err)
+	}
+	return gcm, nil
+}
@@ -0,0 +1,110 @@
+package scriptrunner
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"testing"
+)
+
+func TestSecretEnvelope(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherEC, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []struct {
+		name  string
+		pub   crypto.PublicKey
+		priv  crypto.PrivateKey
+		wrong crypto.PrivateKey
+		alg   string
+	}{
+		{"rsa", &rsaKey.PublicKey, rsaKey, otherRSA, SecretRSA},
+		{"ecdsa", &ecKey.PublicKey, ecKey, otherEC, SecretECDH},
+		{"ecdsa p384", &p384Key.PublicKey, p384Key, ecKey, SecretECDH},
+		{"rsa to ecdsa", &rsaKey.PublicKey, rsaKey, ecKey, SecretRSA},
+	}
+	value := []byte("s3cr3t value")
+	for _, k := range keys {
+		E, err := EncryptSecret(k.pub, "db", value)
+		if err != nil {
+			t.Fatalf("%s: error encrypting: %v", k.name, err)
+		}
+		if E.Algorithm != k.alg {
+			t.Errorf("%s: algorithm %q, want %q", k.name, E.Algorithm, k.alg)
+		}
+		got, err := E.Decrypt(k.priv, "db")
+		if err != nil {
+			t.Fatalf("%s: error decrypting: %v", k.name, err)
+		}
+		if string(got) != string(value) {
+			t.Errorf("%s: decrypted %q, want %q", k.name, got, value)
+		}
+
+		tampered := []struct {
+			name string
+			env  *SecretEnvelope
+			priv crypto.PrivateKey
+			as   string
+		}{
+			{"wrong key", E, k.wrong, "db"},
+			{"wrong name", E, k.priv, "other"},
+			{"tampered ciphertext", flipped(E, &E.Ciphertext), k.priv, "db"},
+			{"tampered nonce", flipped(E, &E.Nonce), k.priv, "db"},
+			{"tampered key", flipped(E, &E.Key), k.priv, "db"},
+			{"wrong algorithm", withAlgorithm(E, "none"), k.priv, "db"},
+		}
+		for _, tt := range tampered {
+			if v, err := tt.env.Decrypt(tt.priv, tt.as); err == nil {
+				t.Errorf("%s: %s decrypted to %q", k.name, tt.name, v)
+			}
+		}
+	}
+}
+
+func TestEncryptSecretUnsupportedKey(t *testing.T) {
+	if _, err := EncryptSecret("not a key", "db", []byte("value")); err == nil {
+		t.Error("secret encrypted to an unsupported key type")
+	}
+}
+
+// flipped returns a copy of the envelope with the last byte of the given base64 field inverted.
+func flipped(e *SecretEnvelope, field *string) *SecretEnvelope {
+	b, _ := base64.StdEncoding.DecodeString(*field)
+	b[len(b)-1] ^= 0xff
+	c := *e
+	switch field {
+	case &e.Ciphertext:
+		c.Ciphertext = base64.StdEncoding.EncodeToString(b)
+	case &e.Nonce:
+		c.Nonce = base64.StdEncoding.EncodeToString(b)
+	case &e.Key:
+		c.Key = base64.StdEncoding.EncodeToString(b)
+	}
+	return &c
+}
+
+func withAlgorithm(e *SecretEnvelope, alg string) *SecretEnvelope {
+	c := *e
+	c.Algorithm = alg
+	return &c
+}
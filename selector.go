@@ -0,0 +1,194 @@
+package scriptrunner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type selectorOp string
+
+const (
+	opEquals    selectorOp = `=`
+	opNotEquals selectorOp = `!=`
+	opIn        selectorOp = `in`
+	opNotIn     selectorOp = `notin`
+	opExists    selectorOp = `exists`
+	opNotExists selectorOp = `!`
+)
+
+// Selector is a parsed label selector expression used to target archives to hosts.
+type Selector struct {
+	reqs []requirement
+}
+
+type requirement struct {
+	key    string
+	op     selectorOp
+	values []string
+}
+
+// ParseSelector parses a comma separated list of requirements, all of which must match.
+// Requirements take the forms "key=value", "key==value", "key!=value", "key in (a,b)",
+// "key notin (a,b)", "key" for a key that exists and "!key" for a key that does not.
+func ParseSelector(expr string) (*Selector, error) {
+	var S Selector
+	parts, err := splitSelector(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %w", expr, err)
+	}
+	for _, p := range parts {
+		req, err := parseRequirement(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %w", expr, err)
+		}
+		S.reqs = append(S.reqs, req)
+	}
+	return &S, nil
+}
+
+// Matches returns true if the given labels satisfy every requirement of the Selector.
+func (s *Selector) Matches(labels map[string]string) bool {
+	return len(s.Unmatched(labels)) == 0
+}
+
+// Unmatched returns the requirements of the Selector not satisfied by the given labels.
+func (s *Selector) Unmatched(labels map[string]string) []string {
+	var unmatched []string
+	for _, r := range s.reqs {
+		if !r.matches(labels) {
+			unmatched = append(unmatched, r.String())
+		}
+	}
+	return unmatched
+}
+
+// String returns the normalized selector expression.
+func (s *Selector) String() string {
+	reqs := make([]string, len(s.reqs))
+	for i, r := range s.reqs {
+		reqs[i] = r.String()
+	}
+	return strings.Join(reqs, `,`)
+}
+
+func (r requirement) matches(labels map[string]string) bool {
+	v, ok := labels[r.key]
+	switch r.op {
+	case opEquals:
+		return ok && v == r.values[0]
+	case opNotEquals:
+		return !ok || v != r.values[0]
+	case opIn:
+		return ok && contains(r.values, v)
+	case opNotIn:
+		return !ok || !contains(r.values, v)
+	case opExists:
+		return ok
+	case opNotExists:
+		return !ok
+	}
+	return false
+}
+
+func (r requirement) String() string {
+	switch r.op {
+	case opEquals, opNotEquals:
+		return r.key + string(r.op) + r.values[0]
+	case opIn, opNotIn:
+		return r.key + ` ` + string(r.op) + ` (` + strings.Join(r.values, `,`) + `)`
+	case opNotExists:
+		return `!` + r.key
+	}
+	return r.key
+}
+
+// splitSelector splits the expression on commas outside of parentheses.
+func splitSelector(expr string) ([]string, error) {
+	var parts []string
+	var depth, start int
+	for i, c := range expr {
+		switch c {
+		case '(':
+			depth++
+			if depth > 1 {
+				return nil, fmt.Errorf("nested parentheses")
+			}
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses")
+			}
+		case ',':
+			if depth == 0 {
+				parts = append(parts, expr[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses")
+	}
+	parts = append(parts, expr[start:])
+	if len(parts) == 1 && strings.TrimSpace(parts[0]) == "" {
+		return nil, nil
+	}
+	return parts, nil
+}
+
+func parseRequirement(s string) (requirement, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return requirement{}, fmt.Errorf("empty requirement")
+	}
+	var R requirement
+	switch {
+	case strings.HasPrefix(s, `!`) && !strings.ContainsAny(s, `=(`):
+		R.key, R.op = strings.TrimSpace(s[1:]), opNotExists
+	case strings.Contains(s, `!=`):
+		i := strings.Index(s, `!=`)
+		R.key, R.op, R.values = strings.TrimSpace(s[:i]), opNotEquals, []string{strings.TrimSpace(s[i+2:])}
+	case strings.Contains(s, `==`):
+		i := strings.Index(s, `==`)
+		R.key, R.op, R.values = strings.TrimSpace(s[:i]), opEquals, []string{strings.TrimSpace(s[i+2:])}
+	case strings.Contains(s, `=`):
+		i := strings.Index(s, `=`)
+		R.key, R.op, R.values = strings.TrimSpace(s[:i]), opEquals, []string{strings.TrimSpace(s[i+1:])}
+	case strings.Contains(s, `(`):
+		fields := strings.Fields(s[:strings.Index(s, `(`)])
+		if len(fields) != 2 || !strings.HasSuffix(s, `)`) {
+			return R, fmt.Errorf("invalid requirement %q", s)
+		}
+		R.key, R.op = fields[0], selectorOp(strings.ToLower(fields[1]))
+		if R.op != opIn && R.op != opNotIn {
+			return R, fmt.Errorf("invalid operator %q in requirement %q", fields[1], s)
+		}
+		for _, v := range strings.Split(s[strings.Index(s, `(`)+1:len(s)-1], `,`) {
+			if v = strings.TrimSpace(v); v != "" {
+				R.values = append(R.values, v)
+			}
+		}
+		if len(R.values) == 0 {
+			return R, fmt.Errorf("no values in requirement %q", s)
+		}
+		sort.Strings(R.values)
+	default:
+		R.key, R.op = s, opExists
+	}
+	switch {
+	case R.key == "" || strings.ContainsAny(R.key, " \t!=()"):
+		return R, fmt.Errorf("invalid key in requirement %q", s)
+	case (R.op == opEquals || R.op == opNotEquals) && strings.ContainsAny(R.values[0], " \t!=()"):
+		return R, fmt.Errorf("invalid value in requirement %q", s)
+	}
+	return R, nil
+}
+
+func contains(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
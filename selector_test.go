@@ -0,0 +1,92 @@
+package scriptrunner
+
+import "testing"
+
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{
+		`env`:  `prod`,
+		`role`: `web`,
+		`dc`:   `east`,
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"", true},
+		{"env=prod", true},
+		{"env==prod", true},
+		{"env=dev", false},
+		{"env!=dev", true},
+		{"env!=prod", false},
+		{"missing!=prod", true},
+		{" env != dev ", true},
+		{"env", true},
+		{"missing", false},
+		{"!missing", true},
+		{"!env", false},
+		{"! env", false},
+		{"env in (dev,prod)", true},
+		{"env IN (dev, test)", false},
+		{"missing in (dev,prod)", false},
+		{"env notin (dev,test)", true},
+		{"env notin (dev,prod)", false},
+		{"missing notin (dev,prod)", true},
+		{"env=prod,role=web", true},
+		{"env=prod,role!=web", false},
+		{"env in (prod,test),!missing,dc!=west", true},
+		{"env in (prod,test),!dc", false},
+	}
+	for _, tt := range tests {
+		S, err := ParseSelector(tt.expr)
+		if err != nil {
+			t.Errorf("ParseSelector(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := S.Matches(labels); got != tt.want {
+			t.Errorf("ParseSelector(%q).Matches() = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseSelectorInvalid(t *testing.T) {
+	for _, expr := range []string{
+		",",
+		"env=prod,",
+		"=prod",
+		"!=prod",
+		"!",
+		"env=a b",
+		"env!=a=b",
+		"env in ()",
+		"env in (a,b",
+		"env in a,b)",
+		"env in ((a))",
+		"env within (a,b)",
+		"env (a,b)",
+	} {
+		if _, err := ParseSelector(expr); err == nil {
+			t.Errorf("ParseSelector(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+func TestSelectorString(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{" env == prod ", "env=prod"},
+		{"env != dev", "env!=dev"},
+		{"! env", "!env"},
+		{"role NOTIN (web, db)", "role notin (db,web)"},
+	}
+	for _, tt := range tests {
+		S, err := ParseSelector(tt.expr)
+		if err != nil {
+			t.Errorf("ParseSelector(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := S.String(); got != tt.want {
+			t.Errorf("ParseSelector(%q).String() = %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
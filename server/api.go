@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -17,19 +18,50 @@ import (
 
 // API for HomeBase.
 type API struct {
-	httpSrv http.Server
-	lock    sync.Mutex
-	wg      sync.WaitGroup
-	logger  *zap.Logger
+	httpSrv  http.Server
+	lock     sync.Mutex
+	wg       sync.WaitGroup
+	logger   *zap.Logger
+	reports  *reportStore
+	clients  *clientStore
+	secrets  string
+	releases string
+	ca       *certAuthority
+	tokens   *tokenStore
+	admins   map[string]bool
 }
 
-// NewAPI returns a new API.
-func NewAPI(host, port, caCertFile string, certOpt tls.ClientAuthType, L *zap.Logger) *API {
+// NewAPI returns a new API storing its data, including up to maxReports reports, within dataDir and serving
+// the client releases within releasesDir.
+// Clients may enroll for certificates issued by ca, if not nil. Clients with a certificate common name
+// within admins may list every client, others only themselves.
+func NewAPI(host, port, caCertFile, dataDir, releasesDir string, maxReports int, admins []string, ca *certAuthority, certOpt tls.ClientAuthType, L *zap.Logger) *API {
 	A := &API{
 		lock:   sync.Mutex{},
 		wg:     sync.WaitGroup{},
 		logger: L.With(zap.String(`process`, `HomeBase API`)),
+		admins: make(map[string]bool, len(admins)),
 	}
+	for _, name := range admins {
+		A.admins[name] = true
+	}
+	if err := createDir(dataDir); err != nil {
+		A.logger.Fatal("error creating data directory", zap.String("directory", dataDir), zap.Error(err))
+	}
+	reports, err := newReportStore(dataDir, maxReports)
+	if err != nil {
+		A.logger.Fatal("error loading reports", zap.Error(err))
+	}
+	A.reports = reports
+	clients, err := newClientStore(dataDir)
+	if err != nil {
+		A.logger.Fatal("error loading clients", zap.Error(err))
+	}
+	A.clients = clients
+	A.secrets = filepath.Join(dataDir, secretsDir)
+	A.releases = releasesDir
+	A.ca = ca
+	A.tokens = newTokenStore(dataDir)
 	A.makeHTTPSrv(host, port, caCertFile, certOpt)
 	return A
 }
@@ -65,7 +97,17 @@ func (a *API) Stop() {
 
 func (a *API) makeHTTPSrv(host, port, caCertFile string, certOpt tls.ClientAuthType) {
 	r := mux.NewRouter()
+	r.Use(requireClientCert)
 	r.HandleFunc(`/`, handleStatus)
+	r.HandleFunc(reportsPath, a.reportsHandler)
+	r.HandleFunc(clientsPath, a.clientsHandler)
+	r.HandleFunc(registerPath, a.checkInHandler(true))
+	r.HandleFunc(heartbeatPath, a.checkInHandler(false))
+	r.HandleFunc(secretsPath, a.secretsHandler)
+	r.HandleFunc(enrollPath, a.enrollHandler)
+	r.HandleFunc(renewPath, a.renewHandler)
+	r.HandleFunc(latestReleasePath, a.releaseHandler(false))
+	r.HandleFunc(downloadReleasePath, a.releaseHandler(true))
 	a.httpSrv = http.Server{
 		Handler:      r,
 		Addr:         `:` + port,
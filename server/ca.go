@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"time"
+
+	"github.com/jbvmio/scriptrunner"
+)
+
+// certAuthority issues client certificates signed by the HomeBase CA.
+type certAuthority struct {
+	cert     *x509.Certificate
+	key      crypto.Signer
+	validity time.Duration
+}
+
+// loadCertAuthority loads the PEM encoded CA certificate and private key used to issue client certificates valid for validity.
+func loadCertAuthority(certFile, keyFile string, validity time.Duration) (*certAuthority, error) {
+	b, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA certificate: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != `CERTIFICATE` {
+		return nil, fmt.Errorf("no PEM certificate found in %q", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CA certificate: %w", err)
+	}
+	k, err := scriptrunner.LoadPrivateKey(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading CA key: %w", err)
+	}
+	key, ok := k.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported CA key type %T", k)
+	}
+	return &certAuthority{
+		cert:     cert,
+		key:      key,
+		validity: validity,
+	}, nil
+}
+
+// parseCSR decodes and verifies the signature of a PEM encoded certificate signing request.
+func parseCSR(data string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil || block.Type != `CERTIFICATE REQUEST` {
+		return nil, fmt.Errorf("no PEM certificate request found")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing certificate request: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid certificate request signature: %w", err)
+	}
+	if csr.Subject.CommonName == "" {
+		return nil, fmt.Errorf("certificate request has no common name")
+	}
+	return csr, nil
+}
+
+// Sign issues a PEM encoded client certificate for the public key and common name of the request.
+func (ca *certAuthority) Sign(csr *x509.CertificateRequest) ([]byte, *x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating serial number: %w", err)
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(ca.validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if template.NotAfter.After(ca.cert.NotAfter) {
+		template.NotAfter = ca.cert.NotAfter
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing certificate: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: `CERTIFICATE`, Bytes: der}), cert, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jbvmio/scriptrunner"
+)
+
+const clientsFile = `clients.json`
+
+// clientStore persists the registered clients as JSON, keyed by the common name of their certificate
+// so that a client can only replace its own record, whatever hostname it reports.
+type clientStore struct {
+	path    string
+	lock    sync.Mutex
+	clients map[string]*scriptrunner.ClientRecord
+}
+
+// newClientStore loads the clients stored within the given directory.
+func newClientStore(dir string) (*clientStore, error) {
+	S := clientStore{
+		path:    filepath.Join(dir, clientsFile),
+		clients: make(map[string]*scriptrunner.ClientRecord),
+	}
+	b, err := ioutil.ReadFile(S.path)
+	switch {
+	case os.IsNotExist(err):
+		return &S, nil
+	case err != nil:
+		return &S, fmt.Errorf("error reading clients file: %w", err)
+	}
+	var clients map[string]*scriptrunner.ClientRecord
+	if err := json.Unmarshal(b, &clients); err != nil {
+		return &S, fmt.Errorf("error unmarshaling clients file: %w", err)
+	}
+	// Records stored by earlier versions were keyed by hostname.
+	for name, c := range clients {
+		if c.Client != "" {
+			name = c.Client
+		}
+		S.clients[name] = c
+	}
+	return &S, nil
+}
+
+// Register creates or replaces the record of the client checking in, keeping its original registration time.
+func (s *clientStore) Register(c scriptrunner.CheckIn, client string) (*scriptrunner.ClientRecord, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now().UTC()
+	rec := scriptrunner.ClientRecord{
+		CheckIn:    c,
+		Client:     client,
+		Registered: now,
+		LastSeen:   now,
+	}
+	if prev, ok := s.clients[client]; ok {
+		rec.Registered = prev.Registered
+	}
+	s.clients[client] = &rec
+	return &rec, s.save()
+}
+
+// Heartbeat updates the record of the client checking in and returns false if it has not registered.
+func (s *clientStore) Heartbeat(c scriptrunner.CheckIn, client string) (*scriptrunner.ClientRecord, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	prev, ok := s.clients[client]
+	if !ok {
+		return nil, false, nil
+	}
+	rec := *prev
+	rec.CheckIn = c
+	rec.Client = client
+	rec.LastSeen = time.Now().UTC()
+	s.clients[client] = &rec
+	return &rec, true, s.save()
+}
+
+// Registered returns true if a client with the given certificate common name has registered.
+func (s *clientStore) Registered(client string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, ok := s.clients[client]
+	return ok
+}
+
+// List returns the registered clients sorted by hostname, optionally limited to the given host.
+func (s *clientStore) List(host string) []*scriptrunner.ClientRecord {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	clients := make([]*scriptrunner.ClientRecord, 0, len(s.clients))
+	for _, c := range s.clients {
+		if host == "" || c.Hostname == host {
+			clients = append(clients, c)
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].Hostname < clients[j].Hostname
+	})
+	return clients
+}
+
+// Expiring returns the clients whose certificates expire within the given duration, soonest first.
+// Clients that have not reported a certificate expiry are left out.
+func (s *clientStore) Expiring(within time.Duration) []*scriptrunner.ClientRecord {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	deadline := time.Now().Add(within)
+	clients := make([]*scriptrunner.ClientRecord, 0)
+	for _, c := range s.clients {
+		if !c.CertNotAfter.IsZero() && c.CertNotAfter.Before(deadline) {
+			clients = append(clients, c)
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].CertNotAfter.Before(clients[j].CertNotAfter)
+	})
+	return clients
+}
+
+func (s *clientStore) save() error {
+	b, err := json.MarshalIndent(s.clients, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling clients: %w", err)
+	}
+	return scriptrunner.WriteFileAtomic(s.path, b, 0640)
+}
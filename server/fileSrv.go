@@ -64,6 +64,7 @@ func (fs *fileSrv) makeHTTPSrv(host, port, srvFiles, caCertFile string, certOpt
 	r.HandleFunc(`/`, handleStatus)
 	r.HandleFunc(`/upload`, uploadFileHandler)
 	r.HandleFunc(`/upload/{filename}`, uploadFileHandler)
+	r.PathPrefix(indexPath).HandlerFunc(indexHandler)
 	r.PathPrefix(filesPath).Handler(http.StripPrefix(filesPath, http.FileServer(http.Dir(srvFiles))))
 	fs.httpSrv = http.Server{
 		Handler:      r,
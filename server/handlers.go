@@ -2,18 +2,33 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/jbvmio/scriptrunner"
 	"github.com/tidwall/pretty"
+	"go.uber.org/zap"
 )
 
+// maxReportSize is the largest report body accepted.
+const maxReportSize = 10 << 20
+
+// secretsDir is the directory within the data directory holding the secrets of each client.
+const secretsDir = `secrets`
+
+// maxCheckInSize is the largest client registration or heartbeat body accepted.
+const maxCheckInSize = 1 << 20
+
 func handleStatus(w http.ResponseWriter, r *http.Request) {
 	writeJSONResponse(w, http.StatusOK, `OK`)
 }
@@ -75,6 +90,304 @@ func uploadFileHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func indexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONErrorWithCode(w, "method not allowed", fmt.Errorf("invalid method: %v", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	dir := path.Clean(`/` + strings.TrimPrefix(r.URL.Path, indexPath))
+	dirPath := filepath.Join(srvFiles, filepath.FromSlash(dir))
+	files, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeJSONErrorWithCode(w, "directory not found", fmt.Errorf("%s", dir), http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, "error reading directory", err)
+		return
+	}
+	index := make([]scriptrunner.RemoteFile, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		hash, err := scriptrunner.FileHash(filepath.Join(dirPath, f.Name()))
+		if err != nil {
+			writeJSONError(w, "error hashing file", err)
+			return
+		}
+		index = append(index, scriptrunner.RemoteFile{
+			Name:    f.Name(),
+			Size:    f.Size(),
+			ModTime: f.ModTime(),
+			SHA256:  hash,
+		})
+	}
+	writeJSONResponse(w, http.StatusOK, index)
+}
+
+// reportsHandler stores the reports clients send and lists them, limited to the reports of the requesting client.
+func (a *API) reportsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var report scriptrunner.RunReport
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxReportSize)).Decode(&report); err != nil {
+			writeJSONErrorWithCode(w, "error decoding report", err, http.StatusBadRequest)
+			return
+		}
+		if report.RunID == "" {
+			writeJSONErrorWithCode(w, "invalid report", fmt.Errorf("runID not specified"), http.StatusBadRequest)
+			return
+		}
+		report.Client = peerName(r)
+		added, err := a.reports.Add(&report)
+		if err != nil {
+			writeJSONError(w, "error storing report", err)
+			return
+		}
+		a.logger.Info("report received", zap.String("runID", report.RunID), zap.String("host", report.Host), zap.String("client", report.Client), zap.Bool("duplicate", !added))
+		writeJSONResponse(w, http.StatusOK, map[string]interface{}{`runID`: report.RunID, `duplicate`: !added})
+	case http.MethodGet:
+		writeJSONResponse(w, http.StatusOK, a.reports.List(peerName(r), r.URL.Query().Get(`host`)))
+	default:
+		writeJSONErrorWithCode(w, "method not allowed", fmt.Errorf("invalid method: %v", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// clientsHandler lists the registered clients. The expiring query parameter, such as 720h,
+// limits the list to clients whose certificates expire within the given duration.
+// Only admin clients see every client, other clients only see their own record.
+func (a *API) clientsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		Q := r.URL.Query()
+		var clients []*scriptrunner.ClientRecord
+		switch expiring := Q.Get(`expiring`); {
+		case expiring != "":
+			within, err := time.ParseDuration(expiring)
+			if err != nil {
+				writeJSONErrorWithCode(w, "invalid expiring duration", err, http.StatusBadRequest)
+				return
+			}
+			clients = a.clients.Expiring(within)
+		default:
+			clients = a.clients.List(Q.Get(`host`))
+		}
+		writeJSONResponse(w, http.StatusOK, a.visibleClients(peerName(r), clients))
+	default:
+		writeJSONErrorWithCode(w, "method not allowed", fmt.Errorf("invalid method: %v", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// visibleClients returns the given clients the requesting client may see.
+func (a *API) visibleClients(client string, clients []*scriptrunner.ClientRecord) []*scriptrunner.ClientRecord {
+	if a.admins[client] {
+		return clients
+	}
+	visible := make([]*scriptrunner.ClientRecord, 0, 1)
+	for _, c := range clients {
+		if c.Client == client {
+			visible = append(visible, c)
+		}
+	}
+	return visible
+}
+
+// checkInHandler returns a handler accepting client registrations, if register is true, or heartbeats.
+// Heartbeats from clients that have not registered are rejected with a 404 so that they register again.
+func (a *API) checkInHandler(register bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONErrorWithCode(w, "method not allowed", fmt.Errorf("invalid method: %v", r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		var c scriptrunner.CheckIn
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCheckInSize)).Decode(&c); err != nil {
+			writeJSONErrorWithCode(w, "error decoding check-in", err, http.StatusBadRequest)
+			return
+		}
+		if c.Hostname == "" {
+			writeJSONErrorWithCode(w, "invalid check-in", fmt.Errorf("hostname not specified"), http.StatusBadRequest)
+			return
+		}
+		var rec *scriptrunner.ClientRecord
+		var err error
+		switch {
+		case register:
+			rec, err = a.clients.Register(c, peerName(r))
+			a.logger.Info("client registered", zap.String("host", c.Hostname), zap.String("client", rec.Client), zap.String("version", c.Version))
+		default:
+			var ok bool
+			rec, ok, err = a.clients.Heartbeat(c, peerName(r))
+			if !ok {
+				writeJSONErrorWithCode(w, "client not registered", fmt.Errorf("unknown client %s", peerName(r)), http.StatusNotFound)
+				return
+			}
+		}
+		if err != nil {
+			writeJSONError(w, "error storing client", err)
+			return
+		}
+		writeJSONResponse(w, http.StatusOK, rec)
+	}
+}
+
+// secretsHandler returns the secrets stored for the requesting client in secrets/<client>.json within the data directory.
+// The secrets are encrypted to the key of the client certificate, so only the client named by it can decrypt them.
+func (a *API) secretsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONErrorWithCode(w, "method not allowed", fmt.Errorf("invalid method: %v", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	client := peerName(r)
+	if client == "" || client != filepath.Base(client) || strings.HasPrefix(client, `.`) {
+		writeJSONErrorWithCode(w, "forbidden", fmt.Errorf("invalid client certificate name %q", client), http.StatusForbidden)
+		return
+	}
+	secrets, err := scriptrunner.LoadSecrets(filepath.Join(a.secrets, client+`.json`))
+	if err != nil {
+		writeJSONError(w, "error loading secrets", err)
+		return
+	}
+	a.logger.Info("secrets requested", zap.String("client", client), zap.Strings("secrets", secrets.Names()))
+	writeJSONResponse(w, http.StatusOK, secrets)
+}
+
+// releaseHandler returns a handler responding with the latest Release for the os and arch query parameters or,
+// if download is true, with its binary. An empty Release is returned if none is published for the platform.
+func (a *API) releaseHandler(download bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONErrorWithCode(w, "method not allowed", fmt.Errorf("invalid method: %v", r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		Q := r.URL.Query()
+		goos, goarch := Q.Get(`os`), Q.Get(`arch`)
+		if !scriptrunner.ValidPlatform(goos, goarch) {
+			writeJSONErrorWithCode(w, "invalid platform", fmt.Errorf("%q/%q", goos, goarch), http.StatusBadRequest)
+			return
+		}
+		dir := filepath.Join(a.releases, goos, goarch)
+		release, err := scriptrunner.LoadRelease(filepath.Join(dir, scriptrunner.ReleaseFile))
+		switch {
+		case errors.Is(err, os.ErrNotExist) && !download:
+			writeJSONResponse(w, http.StatusOK, scriptrunner.Release{})
+			return
+		case errors.Is(err, os.ErrNotExist):
+			writeJSONErrorWithCode(w, "release not found", fmt.Errorf("%s/%s", goos, goarch), http.StatusNotFound)
+			return
+		case err != nil:
+			writeJSONError(w, "error loading release", err)
+			return
+		case !download:
+			writeJSONResponse(w, http.StatusOK, release)
+			return
+		case release.File != filepath.Base(release.File):
+			writeJSONError(w, "invalid release", fmt.Errorf("invalid file %q", release.File))
+			return
+		}
+		a.logger.Info("release downloaded", zap.String("client", peerName(r)), zap.String("version", release.Version), zap.String("platform", goos+`/`+goarch))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeFile(w, r, filepath.Join(dir, release.File))
+	}
+}
+
+// requireClientCert rejects requests without a verified client certificate, except those enrolling for one.
+func requireClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != enrollPath && peerName(r) == "" {
+			writeJSONErrorWithCode(w, "unauthorized", fmt.Errorf("client certificate required"), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// enrollHandler issues a client certificate for a certificate signing request accompanied by a valid one-time token.
+func (a *API) enrollHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONErrorWithCode(w, "method not allowed", fmt.Errorf("invalid method: %v", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	if a.ca == nil {
+		writeJSONErrorWithCode(w, "enrollment disabled", fmt.Errorf("no CA key configured"), http.StatusNotImplemented)
+		return
+	}
+	var req scriptrunner.EnrollRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCheckInSize)).Decode(&req); err != nil {
+		writeJSONErrorWithCode(w, "error decoding enrollment", err, http.StatusBadRequest)
+		return
+	}
+	csr, err := parseCSR(req.CSR)
+	if err != nil {
+		writeJSONErrorWithCode(w, "invalid enrollment", err, http.StatusBadRequest)
+		return
+	}
+	name := csr.Subject.CommonName
+	if err := a.tokens.Use(req.Token, name, a.clients.Registered(name)); err != nil {
+		a.logger.Warn("enrollment rejected", zap.String("client", name), zap.String("remote", r.RemoteAddr), zap.Error(err))
+		writeJSONErrorWithCode(w, "enrollment rejected", err, http.StatusForbidden)
+		return
+	}
+	certPEM, cert, err := a.ca.Sign(csr)
+	if err != nil {
+		writeJSONError(w, "error issuing certificate", err)
+		return
+	}
+	a.logger.Info("client enrolled", zap.String("client", name), zap.String("serial", cert.SerialNumber.Text(16)), zap.Time("notAfter", cert.NotAfter))
+	writeJSONResponse(w, http.StatusOK, scriptrunner.EnrollResponse{
+		Certificate: string(certPEM),
+		NotAfter:    cert.NotAfter,
+	})
+}
+
+// renewHandler issues a new certificate to an authenticated client for a certificate signing request
+// with the common name of its current certificate.
+func (a *API) renewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONErrorWithCode(w, "method not allowed", fmt.Errorf("invalid method: %v", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	if a.ca == nil {
+		writeJSONErrorWithCode(w, "renewal disabled", fmt.Errorf("no CA key configured"), http.StatusNotImplemented)
+		return
+	}
+	var req scriptrunner.EnrollRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCheckInSize)).Decode(&req); err != nil {
+		writeJSONErrorWithCode(w, "error decoding renewal", err, http.StatusBadRequest)
+		return
+	}
+	csr, err := parseCSR(req.CSR)
+	if err != nil {
+		writeJSONErrorWithCode(w, "invalid renewal", err, http.StatusBadRequest)
+		return
+	}
+	client := peerName(r)
+	if csr.Subject.CommonName != client {
+		a.logger.Warn("renewal rejected", zap.String("client", client), zap.String("requested", csr.Subject.CommonName))
+		writeJSONErrorWithCode(w, "renewal rejected", fmt.Errorf("common name does not match client certificate"), http.StatusForbidden)
+		return
+	}
+	certPEM, cert, err := a.ca.Sign(csr)
+	if err != nil {
+		writeJSONError(w, "error issuing certificate", err)
+		return
+	}
+	a.logger.Info("client certificate renewed", zap.String("client", client), zap.String("serial", cert.SerialNumber.Text(16)), zap.Time("notAfter", cert.NotAfter))
+	writeJSONResponse(w, http.StatusOK, scriptrunner.EnrollResponse{
+		Certificate: string(certPEM),
+		NotAfter:    cert.NotAfter,
+	})
+}
+
+// peerName returns the common name of the verified client certificate of the request.
+func peerName(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
 func writeJSONResponse(w http.ResponseWriter, statusCode int, obj interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	if jsonBytes, err := json.Marshal(obj); err != nil {
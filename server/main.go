@@ -5,13 +5,18 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/jbvmio/scriptrunner"
 	"github.com/spf13/pflag"
 	"go.uber.org/zap"
 )
 
-const certOpt = tls.RequireAndVerifyClientCert
+const (
+	certOpt = tls.RequireAndVerifyClientCert
+	// apiCertOpt lets clients without a certificate reach the enrollment endpoint. All other API routes require one.
+	apiCertOpt = tls.VerifyClientCertIfGiven
+)
 
 var (
 	host        string
@@ -20,15 +25,39 @@ var (
 	svrCertFile string
 	svrKeyFile  string
 	srvFiles    string
+	dataDir     string
+	releasesDir string
+	caKeyFile   string
+	admins      []string
+	maxReports  int
+	validity    time.Duration
 	buildTime   string
 	commitHash  string
 )
 
 const (
-	filesPath = `/files/`
+	filesPath   = `/files/`
+	indexPath   = `/index/`
+	reportsPath = `/reports`
+
+	clientsPath   = `/clients`
+	registerPath  = `/clients/register`
+	heartbeatPath = `/clients/heartbeat`
+	secretsPath   = `/secrets`
+
+	enrollPath = `/enroll`
+	renewPath  = `/renew`
+
+	latestReleasePath   = `/releases/latest`
+	downloadReleasePath = `/releases/download`
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == `token` {
+		runToken(os.Args[2:])
+		return
+	}
+
 	pf := pflag.NewFlagSet(`homebase`, pflag.ExitOnError)
 	pf.StringVarP(&host, "host", "h", "localhost", "Name of Host receiving requests.")
 	pf.StringVarP(&port, "port", "p", "8080", "Port to Listen on.")
@@ -36,6 +65,12 @@ func main() {
 	pf.StringVar(&svrCertFile, "cert", "server.crt", "Filepath to Server Certificate.")
 	pf.StringVar(&svrKeyFile, "key", "server.key", "Filepath to Server Certificate.")
 	pf.StringVar(&srvFiles, "filesrv", "", "Run FileServer using the Given Directory.")
+	pf.StringVar(&dataDir, "data", "data", "API: Directory to Store Reports and Client Data.")
+	pf.StringVar(&releasesDir, "releases", "releases", "API: Directory of Client Releases Published by scriptrunner release.")
+	pf.StringVar(&caKeyFile, "cakey", "", "API: Filepath to the CA Private Key, Enables Client Enrollment using Tokens.")
+	pf.IntVar(&maxReports, "max-reports", 10000, "API: Number of Most Recent Reports to Keep, 0 Keeps All.")
+	pf.StringSliceVar(&admins, "admin", nil, "API: Client Certificate Common Names Allowed to List All Clients.")
+	pf.DurationVar(&validity, "cert-validity", 365*24*time.Hour, "API: Validity of Client Certificates Issued by Enrollment.")
 	pf.Parse(os.Args[1:])
 
 	l := scriptrunner.ConfigureLevel(`info`)
@@ -56,7 +91,16 @@ func main() {
 		L.Info("Stopped.")
 
 	default:
-		api := NewAPI(host, port, caCertFile, certOpt, L)
+		var ca *certAuthority
+		if caKeyFile != "" {
+			var err error
+			ca, err = loadCertAuthority(caCertFile, caKeyFile, validity)
+			if err != nil {
+				L.Fatal("error loading CA", zap.Error(err))
+			}
+			L.Info("client enrollment enabled", zap.Duration("validity", validity))
+		}
+		api := NewAPI(host, port, caCertFile, dataDir, releasesDir, maxReports, admins, ca, apiCertOpt, L)
 		api.Start(svrCertFile, svrKeyFile)
 
 		<-sigChan
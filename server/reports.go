@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/jbvmio/scriptrunner"
+)
+
+const reportsFile = `reports.jsonl`
+
+// reportKey identifies a report by the client that sent it and its run ID.
+type reportKey struct {
+	client string
+	runID  string
+}
+
+// reportStore persists run reports as JSON lines and keeps them indexed by client and run ID.
+// Only the newest max reports are kept, zero keeps every report.
+type reportStore struct {
+	path    string
+	max     int
+	lock    sync.Mutex
+	reports []*scriptrunner.RunReport
+	runIDs  map[reportKey]bool
+}
+
+// newReportStore loads the reports stored within the given directory, keeping the newest max reports.
+func newReportStore(dir string, max int) (*reportStore, error) {
+	S := reportStore{
+		path:   filepath.Join(dir, reportsFile),
+		max:    max,
+		runIDs: make(map[reportKey]bool),
+	}
+	f, err := os.Open(S.path)
+	switch {
+	case os.IsNotExist(err):
+		return &S, nil
+	case err != nil:
+		return &S, fmt.Errorf("error opening reports file: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxReportSize)
+	for scanner.Scan() {
+		var report scriptrunner.RunReport
+		if err := json.Unmarshal(scanner.Bytes(), &report); err != nil {
+			return &S, fmt.Errorf("error unmarshaling stored report: %w", err)
+		}
+		S.reports = append(S.reports, &report)
+		S.runIDs[reportKey{report.Client, report.RunID}] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return &S, fmt.Errorf("error reading reports file: %w", err)
+	}
+	if S.max > 0 && len(S.reports) > S.max {
+		return &S, S.compact()
+	}
+	return &S, nil
+}
+
+// Add stores the given report and returns true, or returns false if a report for its run ID was already
+// stored for the same client. The oldest reports exceeding the limit of the store are removed.
+func (s *reportStore) Add(report *scriptrunner.RunReport) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := reportKey{report.Client, report.RunID}
+	if s.runIDs[key] {
+		return false, nil
+	}
+	b, err := json.Marshal(report)
+	if err != nil {
+		return false, fmt.Errorf("error marshaling report: %w", err)
+	}
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return false, fmt.Errorf("error opening reports file: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return false, fmt.Errorf("error writing report: %w", err)
+	}
+	s.reports = append(s.reports, report)
+	s.runIDs[key] = true
+	// Compact only once the limit is exceeded by a tenth, rather than rewriting the file on every report.
+	if s.max > 0 && len(s.reports) > s.max+s.max/10 {
+		return true, s.compact()
+	}
+	return true, nil
+}
+
+// compact removes the oldest reports exceeding the limit of the store and rewrites the reports file.
+func (s *reportStore) compact() error {
+	for _, r := range s.reports[:len(s.reports)-s.max] {
+		delete(s.runIDs, reportKey{r.Client, r.RunID})
+	}
+	s.reports = append([]*scriptrunner.RunReport(nil), s.reports[len(s.reports)-s.max:]...)
+	var buf bytes.Buffer
+	for _, r := range s.reports {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("error marshaling report: %w", err)
+		}
+		buf.Write(append(b, '\n'))
+	}
+	if err := scriptrunner.WriteFileAtomic(s.path, buf.Bytes(), 0640); err != nil {
+		return fmt.Errorf("error compacting reports file: %w", err)
+	}
+	return nil
+}
+
+// List returns the stored reports sent by the given client, optionally limited to the given host.
+func (s *reportStore) List(client, host string) []*scriptrunner.RunReport {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	reports := make([]*scriptrunner.RunReport, 0, len(s.reports))
+	for _, r := range s.reports {
+		if r.Client == client && (host == "" || r.Host == host) {
+			reports = append(reports, r)
+		}
+	}
+	return reports
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jbvmio/scriptrunner"
+	"github.com/spf13/pflag"
+)
+
+const tokensFile = `tokens.json`
+
+// enrollToken is a one-time bootstrap token allowing a client to enroll. Only the hash of the token is stored.
+// If Host is set, the token may only enroll a certificate for that common name.
+type enrollToken struct {
+	Host    string    `json:"host,omitempty"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// tokenStore persists enrollment tokens as JSON, keyed by the SHA-256 hash of each token.
+// The file is read on each use so that tokens created by the token subcommand take effect without a restart.
+type tokenStore struct {
+	path string
+	lock sync.Mutex
+}
+
+func newTokenStore(dir string) *tokenStore {
+	return &tokenStore{path: filepath.Join(dir, tokensFile)}
+}
+
+// Create stores a new token valid for ttl and returns it.
+func (s *tokenStore) Create(host string, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	tokens, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	tokens[tokenHash(token)] = &enrollToken{
+		Host:    host,
+		Created: now,
+		Expires: now.Add(ttl),
+	}
+	return token, s.save(tokens)
+}
+
+// Use consumes the token for enrolling the given common name, removing it along with any expired tokens.
+// A token not bound to a host cannot enroll the common name of an already registered client.
+func (s *tokenStore) Use(token, commonName string, registered bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	tokens, err := s.load()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for hash, t := range tokens {
+		if now.After(t.Expires) {
+			delete(tokens, hash)
+		}
+	}
+	hash := tokenHash(token)
+	t, ok := tokens[hash]
+	switch {
+	case !ok:
+		err = fmt.Errorf("invalid or expired token")
+	case t.Host != "" && t.Host != commonName:
+		err = fmt.Errorf("token not valid for %s", commonName)
+	case t.Host == "" && registered:
+		err = fmt.Errorf("client %s is already registered, a token bound to the host is required", commonName)
+	default:
+		delete(tokens, hash)
+	}
+	if serr := s.save(tokens); serr != nil {
+		return serr
+	}
+	return err
+}
+
+func (s *tokenStore) load() (map[string]*enrollToken, error) {
+	tokens := make(map[string]*enrollToken)
+	b, err := ioutil.ReadFile(s.path)
+	switch {
+	case os.IsNotExist(err):
+		return tokens, nil
+	case err != nil:
+		return tokens, fmt.Errorf("error reading tokens file: %w", err)
+	}
+	if err := json.Unmarshal(b, &tokens); err != nil {
+		return tokens, fmt.Errorf("error unmarshaling tokens file: %w", err)
+	}
+	return tokens, nil
+}
+
+func (s *tokenStore) save(tokens map[string]*enrollToken) error {
+	b, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling tokens: %w", err)
+	}
+	return scriptrunner.WriteFileAtomic(s.path, b, 0600)
+}
+
+// runToken creates a one-time enrollment token and prints it.
+func runToken(args []string) {
+	var dir, host string
+	var ttl time.Duration
+	pf := pflag.NewFlagSet(`homebase token`, pflag.ExitOnError)
+	pf.StringVar(&dir, "data", "data", "Directory to Store Reports and Client Data.")
+	pf.StringVar(&host, "host", "", "Only Allow Enrolling a Certificate with the Given Common Name, Required to Re-Enroll a Registered Client.")
+	pf.DurationVar(&ttl, "ttl", 24*time.Hour, "Time the Token Remains Valid.")
+	pf.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: homebase token create [flags]\n")
+		pf.PrintDefaults()
+	}
+	pf.Parse(args)
+	if pf.NArg() != 1 || pf.Arg(0) != `create` {
+		pf.Usage()
+		os.Exit(2)
+	}
+	if err := createDir(dir); err != nil {
+		log.Fatalf("error creating data directory: %v\n", err)
+	}
+	token, err := newTokenStore(dir).Create(host, ttl)
+	if err != nil {
+		log.Fatalf("error creating token: %v\n", err)
+	}
+	fmt.Println(token)
+}
+
+func tokenHash(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
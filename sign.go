@@ -0,0 +1,53 @@
+package scriptrunner
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+)
+
+// LoadSigningKey reads a PEM encoded PKCS #8 ed25519 private key from the given filepath,
+// such as one created by "openssl genpkey -algorithm ed25519".
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading signing key: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %q", path)
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing signing key: %w", err)
+	}
+	key, ok := k.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %q is not an ed25519 key", path)
+	}
+	return key, nil
+}
+
+// LoadVerifyKey reads a PEM encoded PKIX ed25519 public key from the given filepath,
+// such as one created by "openssl pkey -pubout".
+func LoadVerifyKey(path string) (ed25519.PublicKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading verify key: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %q", path)
+	}
+	k, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing verify key: %w", err)
+	}
+	key, ok := k.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("verify key %q is not an ed25519 key", path)
+	}
+	return key, nil
+}
@@ -0,0 +1,137 @@
+package scriptrunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RunPolicy determines whether an archive that has already been executed runs again.
+type RunPolicy string
+
+// Available RunPolicies.
+const (
+	// RunAlways runs the archive every time.
+	RunAlways RunPolicy = `always`
+	// RunOnce runs the archive a single time, regardless of its outcome or later changes.
+	RunOnce RunPolicy = `once`
+	// RunOnChange runs the archive whenever its content differs from the last run.
+	RunOnChange RunPolicy = `onChange`
+	// RunUntilSuccess runs the archive until its current content has succeeded.
+	RunUntilSuccess RunPolicy = `untilSuccess`
+)
+
+// Valid returns true if the RunPolicy is known. An empty RunPolicy is treated as RunAlways.
+func (p RunPolicy) Valid() bool {
+	switch p {
+	case "", RunAlways, RunOnce, RunOnChange, RunUntilSuccess:
+		return true
+	}
+	return false
+}
+
+// Satisfied returns true if an archive with the given content hash needs no further runs
+// according to the RunPolicy and the previous ArchiveState, if any.
+func (p RunPolicy) Satisfied(hash string, prev ArchiveState, ok bool) bool {
+	if !ok {
+		return false
+	}
+	switch p {
+	case RunOnce:
+		return true
+	case RunOnChange:
+		return prev.Hash == hash
+	case RunUntilSuccess:
+		return prev.Hash == hash && prev.Status == StatusSucceeded
+	}
+	return false
+}
+
+// ArchiveState records the last run of an archive.
+type ArchiveState struct {
+	Hash    string    `json:"hash"`
+	RunID   string    `json:"runID"`
+	LastRun time.Time `json:"lastRun"`
+	Status  Status    `json:"status"`
+}
+
+// State is a local store of the archives that have been executed, keyed by archive name.
+type State struct {
+	path     string
+	lock     sync.Mutex
+	Archives map[string]ArchiveState `json:"archives"`
+}
+
+// LoadState reads the State stored at the given filepath. An empty State is returned if the file does not exist.
+func LoadState(path string) (*State, error) {
+	S := State{
+		path:     path,
+		Archives: make(map[string]ArchiveState),
+	}
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return &S, nil
+	case err != nil:
+		return &S, fmt.Errorf("error reading state file: %w", err)
+	}
+	if err := json.Unmarshal(b, &S); err != nil {
+		return &S, fmt.Errorf("error unmarshaling state file: %w", err)
+	}
+	if S.Archives == nil {
+		S.Archives = make(map[string]ArchiveState)
+	}
+	return &S, nil
+}
+
+// Get returns the ArchiveState for the named archive and whether it exists.
+func (s *State) Get(name string) (ArchiveState, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	as, ok := s.Archives[name]
+	return as, ok
+}
+
+// Record stores the ArchiveState for the named archive and saves the State.
+func (s *State) Record(name string, as ArchiveState) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Archives[name] = as
+	return s.save()
+}
+
+func (s *State) save() error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling state: %w", err)
+	}
+	return WriteFileAtomic(s.path, b, 0600)
+}
+
+// WriteFileAtomic writes data to a temporary file next to the given filepath and renames it into place.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), `.`+filepath.Base(path)+`.*`)
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing temporary file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error syncing temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error closing temporary file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return fmt.Errorf("error setting permissions for temporary file: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
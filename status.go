@@ -0,0 +1,38 @@
+package scriptrunner
+
+// Status is the outcome of an archive or script.
+type Status string
+
+// Available Statuses.
+const (
+	StatusSucceeded        Status = `succeeded`
+	StatusFailed           Status = `failed`
+	StatusDependencyFailed Status = `dependency failed`
+	StatusSatisfied        Status = `satisfied`
+	StatusCanceled         Status = `canceled`
+	StatusNotDue           Status = `not due`
+	StatusDeferred         Status = `deferred`
+	StatusSkipped          Status = `skipped`
+)
+
+// FailurePolicy determines what happens after a script fails.
+type FailurePolicy string
+
+// Available FailurePolicies.
+const (
+	// FailContinue runs the remaining scripts and archives that do not depend on the failed script.
+	FailContinue FailurePolicy = `continue`
+	// FailStopArchive skips the remaining scripts of the archive containing the failed script.
+	FailStopArchive FailurePolicy = `stopArchive`
+	// FailStopAll skips the remaining scripts of the archive and cancels all other remaining scripts and archives.
+	FailStopAll FailurePolicy = `stopAll`
+)
+
+// Valid returns true if the FailurePolicy is known. An empty FailurePolicy is treated as FailContinue.
+func (p FailurePolicy) Valid() bool {
+	switch p {
+	case "", FailContinue, FailStopArchive, FailStopAll:
+		return true
+	}
+	return false
+}
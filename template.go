@@ -0,0 +1,85 @@
+package scriptrunner
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// TemplateSuffix marks files within an archive that are rendered before its scripts are discovered.
+// The rendered file replaces the template under its name without the suffix.
+const TemplateSuffix = `.tmpl`
+
+// TemplateData contains the variables available to templates.
+type TemplateData struct {
+	Host   HostFacts
+	Labels map[string]string
+	Vars   map[string]string
+	Params map[string]string
+	Run    TemplateRun
+}
+
+// TemplateRun describes the run and archive a template is rendered for.
+type TemplateRun struct {
+	ID      string
+	Archive string
+	Name    string
+	Dir     string
+}
+
+// RenderTemplates renders every file with the TemplateSuffix within dir and returns the
+// slash separated paths of the rendered files relative to dir.
+func RenderTemplates(dir string, data *TemplateData) ([]string, error) {
+	var rendered []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), TemplateSuffix) {
+			return nil
+		}
+		dst := strings.TrimSuffix(path, TemplateSuffix)
+		if err := RenderFile(path, dst, data); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("error removing template: %w", err)
+		}
+		rel, err := filepath.Rel(dir, dst)
+		if err != nil {
+			return err
+		}
+		rendered = append(rendered, filepath.ToSlash(rel))
+		return nil
+	})
+	return rendered, err
+}
+
+// RenderFile renders the template file src to dst, which may be the same file.
+// Referencing a variable that does not exist is an error.
+func RenderFile(src, dst string, data *TemplateData) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("error reading template: %w", err)
+	}
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("error reading template: %w", err)
+	}
+	t, err := template.New(filepath.Base(src)).Option(`missingkey=error`).Parse(string(b))
+	if err != nil {
+		return fmt.Errorf("error parsing template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return fmt.Errorf("error rendering template: %w", err)
+	}
+	if err := ioutil.WriteFile(dst, buf.Bytes(), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("error writing rendered template: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package scriptrunner
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaintenanceWindow defines a recurring period during which disruptive archives may run.
+// Days contains weekday names such as "mon" or "saturday" and is every day when empty.
+// Start and End are "HH:MM" times, and a window ending at or before its start spans midnight.
+// An empty Timezone uses the local timezone.
+type MaintenanceWindow struct {
+	Name     string   `yaml:"name"`
+	Days     []string `yaml:"days,omitempty"`
+	Start    string   `yaml:"start"`
+	End      string   `yaml:"end"`
+	Timezone string   `yaml:"timezone,omitempty"`
+}
+
+type parsedWindow struct {
+	days       map[time.Weekday]bool
+	start, end time.Duration
+	loc        *time.Location
+}
+
+// Validate returns an error if the MaintenanceWindow contains invalid options.
+func (w *MaintenanceWindow) Validate() error {
+	_, err := w.parse()
+	return err
+}
+
+// Contains returns true if t falls within the MaintenanceWindow.
+func (w *MaintenanceWindow) Contains(t time.Time) bool {
+	p, err := w.parse()
+	if err != nil {
+		return false
+	}
+	t = t.In(p.loc)
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, p.loc)
+	for _, day := range []time.Time{today, today.AddDate(0, 0, -1)} {
+		if !p.days[day.Weekday()] {
+			continue
+		}
+		start, end := p.bounds(day)
+		if !t.Before(start) && t.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextStart returns the next time the MaintenanceWindow opens after t.
+func (w *MaintenanceWindow) NextStart(t time.Time) time.Time {
+	p, err := w.parse()
+	if err != nil {
+		return time.Time{}
+	}
+	t = t.In(p.loc)
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, p.loc)
+	for i := 0; i <= 7; i++ {
+		day := today.AddDate(0, 0, i)
+		if !p.days[day.Weekday()] {
+			continue
+		}
+		if start, _ := p.bounds(day); start.After(t) {
+			return start
+		}
+	}
+	return time.Time{}
+}
+
+// NextMaintenanceWindow returns whether t falls within any of the given windows.
+// If it does not, the window opening soonest after t is returned along with its start time.
+func NextMaintenanceWindow(windows []MaintenanceWindow, t time.Time) (bool, *MaintenanceWindow, time.Time) {
+	var next *MaintenanceWindow
+	var nextStart time.Time
+	for i := range windows {
+		w := &windows[i]
+		if w.Contains(t) {
+			return true, w, t
+		}
+		s := w.NextStart(t)
+		if !s.IsZero() && (nextStart.IsZero() || s.Before(nextStart)) {
+			next, nextStart = w, s
+		}
+	}
+	return false, next, nextStart
+}
+
+func (p *parsedWindow) bounds(day time.Time) (time.Time, time.Time) {
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, int(p.start/time.Minute), 0, 0, p.loc)
+	end := time.Date(day.Year(), day.Month(), day.Day(), 0, int(p.end/time.Minute), 0, 0, p.loc)
+	if p.end <= p.start {
+		end = end.AddDate(0, 0, 1)
+	}
+	return start, end
+}
+
+func (w *MaintenanceWindow) parse() (*parsedWindow, error) {
+	P := parsedWindow{
+		days: make(map[time.Weekday]bool, 7),
+		loc:  time.Local,
+	}
+	var err error
+	if w.Timezone != "" {
+		if P.loc, err = time.LoadLocation(w.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone %q for window %q: %w", w.Timezone, w.Name, err)
+		}
+	}
+	if P.start, err = parseClock(w.Start); err != nil {
+		return nil, fmt.Errorf("invalid start for window %q: %w", w.Name, err)
+	}
+	if P.end, err = parseClock(w.End); err != nil {
+		return nil, fmt.Errorf("invalid end for window %q: %w", w.Name, err)
+	}
+	if len(w.Days) == 0 {
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			P.days[d] = true
+		}
+	}
+	for _, d := range w.Days {
+		wd, ok := parseWeekday(d)
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q for window %q", d, w.Name)
+		}
+		P.days[wd] = true
+	}
+	return &P, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse(`15:04`, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func parseWeekday(s string) (time.Weekday, bool) {
+	s = strings.ToLower(s)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if s == name || s == name[:3] {
+			return d, true
+		}
+	}
+	return 0, false
+}
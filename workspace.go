@@ -0,0 +1,207 @@
+package scriptrunner
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FailedDir is the directory under the workspace root where the workspaces of failed archives are preserved.
+const FailedDir = `failed`
+
+const (
+	workspacePerm = 0700
+	pidFile       = `.pid`
+)
+
+// Retention defines the limits for preserved workspaces. Zero values are unlimited.
+type Retention struct {
+	MaxCount  int           `yaml:"maxCount"`
+	MaxAge    time.Duration `yaml:"maxAge"`
+	MaxSizeMB int64         `yaml:"maxSizeMB"`
+}
+
+// Workspace contains the isolated directory for a single run and the directories of the archives within it.
+type Workspace struct {
+	Root   string
+	RunID  string
+	RunDir string
+}
+
+// NewRunID returns a unique, time ordered identifier for a run.
+func NewRunID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return time.Now().UTC().Format(`20060102T150405`) + `-` + hex.EncodeToString(b)
+}
+
+// NewWorkspace creates the run directory for the given runID under the root workspace directory
+// and records the current process as its owner.
+func NewWorkspace(root, runID string) (*Workspace, error) {
+	W := Workspace{
+		Root:   root,
+		RunID:  runID,
+		RunDir: filepath.Join(root, runID),
+	}
+	if err := CreateDir(root); err != nil {
+		return &W, fmt.Errorf("error creating workspace root: %w", err)
+	}
+	if err := createPrivateDir(W.RunDir); err != nil {
+		return &W, fmt.Errorf("error creating run directory: %w", err)
+	}
+	err := ioutil.WriteFile(filepath.Join(W.RunDir, pidFile), []byte(strconv.Itoa(os.Getpid())), 0600)
+	if err != nil {
+		return &W, fmt.Errorf("error writing run pid file: %w", err)
+	}
+	return &W, nil
+}
+
+// ArchiveDir creates and returns an empty directory dedicated to the given archive within the run directory.
+func (w *Workspace) ArchiveDir(archive string) (string, error) {
+	dir := filepath.Join(w.RunDir, ArchiveName(archive))
+	if err := os.RemoveAll(dir); err != nil {
+		return dir, fmt.Errorf("error removing existing archive directory: %w", err)
+	}
+	if err := createPrivateDir(dir); err != nil {
+		return dir, fmt.Errorf("error creating archive directory: %w", err)
+	}
+	return dir, nil
+}
+
+// RemoveArchiveDir deletes the directory of the given archive.
+func (w *Workspace) RemoveArchiveDir(archive string) error {
+	return os.RemoveAll(filepath.Join(w.RunDir, ArchiveName(archive)))
+}
+
+// PreserveArchiveDir moves the directory of the given archive to failed/<run-id>/<archive> under the
+// workspace root instead of deleting it, and returns its new location.
+func (w *Workspace) PreserveArchiveDir(archive string) (string, error) {
+	runDir := filepath.Join(w.Root, FailedDir, w.RunID)
+	if err := createPrivateDir(runDir); err != nil {
+		return "", fmt.Errorf("error creating failed run directory: %w", err)
+	}
+	dst := filepath.Join(runDir, ArchiveName(archive))
+	if err := os.Rename(filepath.Join(w.RunDir, ArchiveName(archive)), dst); err != nil {
+		return "", fmt.Errorf("error moving archive directory: %w", err)
+	}
+	return dst, nil
+}
+
+// Close deletes the run directory and everything under it.
+func (w *Workspace) Close() error {
+	return os.RemoveAll(w.RunDir)
+}
+
+// CleanStaleWorkspaces deletes everything under the root workspace directory that does not belong
+// to a run of a live process, such as the directories left behind by crashed runs.
+// The names of the deleted entries are returned.
+func CleanStaleWorkspaces(root string) ([]string, error) {
+	files, err := GetDirFiles(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error retrieving workspace files: %w", err)
+	}
+	var removed, errFiles []string
+	var errd error
+	for _, f := range files {
+		if f.IsDir && (f.Name == FailedDir || runOwnerAlive(f.FullPath)) {
+			continue
+		}
+		if err := os.RemoveAll(f.FullPath); err != nil {
+			errd = err
+			errFiles = append(errFiles, f.Name)
+			continue
+		}
+		removed = append(removed, f.Name)
+	}
+	if len(errFiles) > 0 {
+		return removed, fmt.Errorf("error deleting %d stale workspaces: %v : lasterr: %v", len(errFiles), errFiles, errd)
+	}
+	return removed, nil
+}
+
+// PruneFailedWorkspaces deletes the preserved workspaces under the workspace root, oldest first,
+// which exceed any of the given Retention limits. The names of the deleted runs are returned.
+func PruneFailedWorkspaces(root string, r Retention) ([]string, error) {
+	files, err := ioutil.ReadDir(filepath.Join(root, FailedDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error retrieving failed workspaces: %w", err)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].ModTime().After(files[j].ModTime())
+	})
+	var removed, errFiles []string
+	var errd error
+	var count int
+	var size int64
+	for _, f := range files {
+		path := filepath.Join(root, FailedDir, f.Name())
+		count++
+		size += dirSize(path)
+		switch {
+		case r.MaxCount > 0 && count > r.MaxCount:
+		case r.MaxAge > 0 && time.Since(f.ModTime()) > r.MaxAge:
+		case r.MaxSizeMB > 0 && size > r.MaxSizeMB*1024*1024:
+		default:
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			errd = err
+			errFiles = append(errFiles, f.Name())
+			continue
+		}
+		removed = append(removed, f.Name())
+	}
+	if len(errFiles) > 0 {
+		return removed, fmt.Errorf("error deleting %d failed workspaces: %v : lasterr: %v", len(errFiles), errFiles, errd)
+	}
+	return removed, nil
+}
+
+// ArchiveName returns the name of an archive file without its directory and extension.
+func ArchiveName(archive string) string {
+	name := filepath.Base(archive)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+func runOwnerAlive(runDir string) bool {
+	b, err := ioutil.ReadFile(filepath.Join(runDir, pidFile))
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return false
+	}
+	return processAlive(pid)
+}
+
+func dirSize(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+func createPrivateDir(path string) error {
+	if err := os.MkdirAll(path, workspacePerm); err != nil {
+		return err
+	}
+	return os.Chmod(path, workspacePerm)
+}